@@ -3,9 +3,14 @@ package godocker
 import (
 	"fmt"
 	"os"
+	"time"
 
+	"godocker/internal/cgroup"
 	"godocker/internal/cgroup/subsystem"
+	"godocker/internal/config"
 	"godocker/internal/container"
+	"godocker/internal/storage"
+	"godocker/pkg"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -21,6 +26,14 @@ var containerCommand = cli.Command{
 		listCommand,
 		logsCommand,
 		removeCommand,
+		inspectCommand,
+		startCommand,
+		stopCommand,
+		killCommand,
+		restartCommand,
+		pauseCommand,
+		unpauseCommand,
+		waitCommand,
 	},
 }
 
@@ -33,9 +46,21 @@ var runCommand = cli.Command{
 			Name:  "it",
 			Usage: "enable tty",
 		},
-		cli.StringFlag{
+		cli.StringSliceFlag{
 			Name:  "v",
-			Usage: "volume",
+			Usage: "Bind mount a volume (host:ctr[:ro|rw][,z] or volume:ctr)",
+		},
+		cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "Mount the container's root filesystem as read only",
+		},
+		cli.StringSliceFlag{
+			Name:  "tmpfs",
+			Usage: "Mount a tmpfs directory (/path[:size=64m,mode=1777])",
+		},
+		cli.StringSliceFlag{
+			Name:  "mount",
+			Usage: "Attach a filesystem mount (type=bind|volume|tmpfs,src=,dst=,readonly,bind-propagation=)",
 		},
 		cli.BoolFlag{
 			Name:  "d",
@@ -43,20 +68,42 @@ var runCommand = cli.Command{
 		},
 		cli.StringFlag{
 			Name:  "mem",
-			Usage: "memory limit",
+			Usage: "Memory limit (e.g. 100m, 1g), -1 for unlimited",
 		},
 		cli.StringFlag{
 			Name:  "cpushare",
 			Usage: "cpushare limit",
 		},
+		cli.StringFlag{
+			Name:  "cpus",
+			Usage: "Number of CPUs",
+		},
 		cli.StringFlag{
 			Name:  "cpuset",
 			Usage: "cpuset limit",
 		},
+		cli.StringFlag{
+			Name: "cgroup-parent",
+			Usage: "Parent cgroup of the container, the container cgroup is <cgroup-parent>/<id> (default godocker), " +
+				"with the systemd cgroup driver a slice containing godocker-<id>.scope (default godocker.slice)",
+		},
+		cli.StringFlag{
+			Name:  "restart",
+			Usage: "Restart policy to apply when a container exits (no, on-failure[:max-retries], always, unless-stopped)",
+			Value: container.RestartPolicyNo,
+		},
 		cli.StringFlag{
 			Name:  "name",
 			Usage: "Assign a name to the container",
 		},
+		cli.StringFlag{
+			Name:  "hostname",
+			Usage: "Container host name",
+		},
+		cli.StringSliceFlag{
+			Name:  "label, l",
+			Usage: "Set meta data on a container (key=value)",
+		},
 		cli.StringSliceFlag{
 			Name:  "e",
 			Usage: "Set environment variables",
@@ -69,6 +116,14 @@ var runCommand = cli.Command{
 			Name:  "p",
 			Usage: "port mapping",
 		},
+		cli.StringFlag{
+			Name:  "storage-driver",
+			Usage: "Storage driver of container rootfs (overlay2, vfs)",
+		},
+		cli.StringSliceFlag{
+			Name:  "storage-opt",
+			Usage: "Storage driver options for the container (size=2G)",
+		},
 	},
 	Action: func(ctx *cli.Context) error {
 		if len(ctx.Args()) < 1 {
@@ -76,35 +131,94 @@ var runCommand = cli.Command{
 		}
 
 		tty := ctx.Bool("it")
-		volume := ctx.String("v")
+		var mounts []container.Mount
+		for _, spec := range ctx.StringSlice("v") {
+			m, err := container.ParseVolume(spec)
+			if err != nil {
+				return err
+			}
+			mounts = append(mounts, m)
+		}
+		for _, spec := range ctx.StringSlice("tmpfs") {
+			m, err := container.ParseTmpfs(spec)
+			if err != nil {
+				return err
+			}
+			mounts = append(mounts, m)
+		}
+		for _, spec := range ctx.StringSlice("mount") {
+			m, err := container.ParseMount(spec)
+			if err != nil {
+				return err
+			}
+			mounts = append(mounts, m)
+		}
 		detach := ctx.Bool("d")
 		name := ctx.String("name")
 
 		if tty && detach {
 			return fmt.Errorf("it and d paramter can't both provided")
 		}
+		restartPolicy, err := container.ParseRestartPolicy(ctx.String("restart"))
+		if err != nil {
+			return err
+		}
+		// 只有后台容器由 shim 等待，前台容器退出后直接删除
+		if tty && restartPolicy.Name != container.RestartPolicyNo {
+			return fmt.Errorf("restart policy can't be used with it")
+		}
 
 		res := &subsystem.ResourceConfig{
 			MemoryLimit: ctx.String("mem"),
 			CpuShare:    ctx.String("cpushare"),
+			Cpus:        ctx.String("cpus"),
 			CpuSet:      ctx.String("cpuset"),
 		}
+		if res.MemoryLimit != "" {
+			if _, err := subsystem.ParseMemoryLimit(res.MemoryLimit); err != nil {
+				return err
+			}
+		}
+		if res.Cpus != "" {
+			if _, _, err := subsystem.ParseCpus(res.Cpus); err != nil {
+				return err
+			}
+		}
+		if _, err := cgroup.ContainerPath(config.Get().CgroupDriver, ctx.String("cgroup-parent"), ""); err != nil {
+			return err
+		}
 		commands := ctx.Args()
 		image := commands[0]
 		commands = commands[1:]
 		envs := ctx.StringSlice("e")
 		portMappings := ctx.StringSlice("p")
 		network := ctx.String("net")
+		storageDriver := ctx.String("storage-driver")
+		storageOpt, err := storage.ParseStorageOpt(ctx.StringSlice("storage-opt"))
+		if err != nil {
+			return err
+		}
+		labels, err := parseLabels(ctx.StringSlice("label"))
+		if err != nil {
+			return err
+		}
 
 		Run(tty, commands,
 			container.WithContainerName(name),
+			container.WithHostname(ctx.String("hostname")),
+			container.WithLabels(labels),
 			container.WithResourceConfig(res),
-			container.WithVolume(volume),
+			container.WithCgroupParent(ctx.String("cgroup-parent")),
+			container.WithMounts(mounts),
+			container.WithReadOnly(ctx.Bool("read-only")),
 			container.WithDetach(detach),
+			container.WithRestartPolicy(restartPolicy),
 			container.WithImage(image),
 			container.WithEnv(envs),
 			container.WithNetwork(network),
 			container.WithPortMapping(portMappings),
+			container.WithStorageDriver(storageDriver),
+			container.WithStorageOpt(storageOpt),
 		)
 		return nil
 	},
@@ -141,12 +255,30 @@ var execCommand = cli.Command{
 var listCommand = cli.Command{
 	Name:  "ls",
 	Usage: "List containers",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "size, s",
+			Usage: "Display write layer size and quota",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
-		container.ListContainer()
+		container.ListContainer(ctx.Bool("size"))
 		return nil
 	},
 }
 
+var inspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "Display detailed information on one or more containers",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+
+		return container.InspectContainer(ctx.Args())
+	},
+}
+
 var logsCommand = cli.Command{
 	Name:  "logs",
 	Usage: "Fetch the logs of a container",
@@ -163,6 +295,20 @@ var logsCommand = cli.Command{
 var commitCommand = cli.Command{
 	Name:  "commit",
 	Usage: "Create a new image from a container's changes",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "author, a",
+			Usage: "Author (e.g., \"kexin <kexin@example.com>\")",
+		},
+		cli.StringFlag{
+			Name:  "message, m",
+			Usage: "Commit message",
+		},
+		cli.StringSliceFlag{
+			Name:  "change, c",
+			Usage: "Apply Dockerfile instruction to the created image (ENV, CMD, ENTRYPOINT, WORKDIR)",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
 		if len(ctx.Args()) < 1 {
 			return fmt.Errorf("missing container command")
@@ -170,35 +316,217 @@ var commitCommand = cli.Command{
 		containerName := ctx.Args().Get(0)
 		imageName := ctx.Args().Get(1)
 
-		container.CommitContainer(containerName, imageName)
+		id, err := container.CommitContainer(containerName, imageName, container.CommitOptions{
+			Author:  ctx.String("author"),
+			Message: ctx.String("message"),
+			Changes: ctx.StringSlice("change"),
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println(id)
 		return nil
 	},
 }
 
 var stopCommand = cli.Command{
 	Name:  "stop",
-	Usage: "Stop running container",
+	Usage: "Stop one or more running containers",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "time, t",
+			Usage: "Seconds to wait for stop before killing it",
+			Value: 10,
+		},
+	},
 	Action: func(ctx *cli.Context) error {
 		if len(ctx.Args()) < 1 {
-			return fmt.Errorf("missing container command")
+			return fmt.Errorf("missing container name")
+		}
+
+		timeout := time.Duration(ctx.Int("time")) * time.Second
+		for _, containerName := range ctx.Args() {
+			if err := container.StopContainer(containerName, timeout); err != nil {
+				logrus.Errorf("Stop container %s error: %v", containerName, err)
+				continue
+			}
+			fmt.Println(containerName)
+		}
+
+		return nil
+	},
+}
+
+var killCommand = cli.Command{
+	Name:  "kill",
+	Usage: "Kill one or more running containers",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "signal, s",
+			Usage: "Signal to send to the container",
+			Value: "KILL",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+
+		sig, err := pkg.ParseSignal(ctx.String("signal"))
+		if err != nil {
+			return err
+		}
+		for _, containerName := range ctx.Args() {
+			if err := container.SignalContainer(containerName, sig); err != nil {
+				logrus.Errorf("Kill container %s error: %v", containerName, err)
+				continue
+			}
+			fmt.Println(containerName)
+		}
+
+		return nil
+	},
+}
+
+var startCommand = cli.Command{
+	Name:  "start",
+	Usage: "Start one or more stopped containers",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+
+		for _, containerName := range ctx.Args() {
+			if err := Start(containerName); err != nil {
+				logrus.Errorf("Start container %s error: %v", containerName, err)
+				continue
+			}
+			fmt.Println(containerName)
 		}
-		containerName := ctx.Args().First()
-		container.StopContainer(containerName)
 
 		return nil
 	},
 }
 
+var restartCommand = cli.Command{
+	Name:  "restart",
+	Usage: "Restart one or more containers",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "time, t",
+			Usage: "Seconds to wait for stop before killing the container",
+			Value: 10,
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+
+		timeout := time.Duration(ctx.Int("time")) * time.Second
+		for _, containerName := range ctx.Args() {
+			if err := Restart(containerName, timeout); err != nil {
+				logrus.Errorf("Restart container %s error: %v", containerName, err)
+				continue
+			}
+			fmt.Println(containerName)
+		}
+
+		return nil
+	},
+}
+
+var pauseCommand = cli.Command{
+	Name:  "pause",
+	Usage: "Pause all processes within one or more containers",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+
+		for _, containerName := range ctx.Args() {
+			if err := container.PauseContainer(containerName); err != nil {
+				logrus.Errorf("Pause container %s error: %v", containerName, err)
+				continue
+			}
+			fmt.Println(containerName)
+		}
+
+		return nil
+	},
+}
+
+var unpauseCommand = cli.Command{
+	Name:  "unpause",
+	Usage: "Unpause all processes within one or more containers",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+
+		for _, containerName := range ctx.Args() {
+			if err := container.UnpauseContainer(containerName); err != nil {
+				logrus.Errorf("Unpause container %s error: %v", containerName, err)
+				continue
+			}
+			fmt.Println(containerName)
+		}
+
+		return nil
+	},
+}
+
+var waitCommand = cli.Command{
+	Name:  "wait",
+	Usage: "Block until one or more containers stop, then print their exit codes",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+
+		// 以第一个容器的结果作为命令的退出码，等待第一个容器出错时退出码为 1
+		var status int
+		for i, containerName := range ctx.Args() {
+			exitCode, err := container.WaitContainer(containerName)
+			if err != nil {
+				logrus.Errorf("Wait container %s error: %v", containerName, err)
+				exitCode = 1
+			} else {
+				fmt.Println(exitCode)
+			}
+			if i == 0 {
+				status = exitCode
+			}
+		}
+
+		if status != 0 {
+			return cli.NewExitError("", status&0xff)
+		}
+		return nil
+	},
+}
+
 var removeCommand = cli.Command{
 	Name:  "rm",
-	Usage: "Remove container",
+	Usage: "Remove one or more containers",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "force, f",
+			Usage: "Force the removal of a running container (uses SIGKILL)",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
 		if len(ctx.Args()) < 1 {
 			return fmt.Errorf("missing container command")
 		}
 
-		containerName := ctx.Args().First()
-		container.RemoveContainer(containerName)
+		for _, containerName := range ctx.Args() {
+			if err := removeContainer(containerName, ctx.Bool("force")); err != nil {
+				logrus.Errorf("Remove container %s error: %v", containerName, err)
+				continue
+			}
+			fmt.Println(containerName)
+		}
 
 		return nil
 	},
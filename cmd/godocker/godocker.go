@@ -3,6 +3,8 @@ package godocker
 import (
 	"os"
 
+	"godocker/internal/cgroup"
+	"godocker/internal/config"
 	_ "godocker/internal/nsenter"
 
 	"github.com/sirupsen/logrus"
@@ -16,25 +18,67 @@ type Docker struct {
 func NewApp() *Docker {
 	cliApp := cli.NewApp()
 	cliApp.Usage = "godocker is a simple container runtime"
+	cliApp.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "root",
+			Usage:  "Root directory of persistent godocker state",
+			Value:  config.DefaultRoot,
+			EnvVar: config.EnvRoot,
+		},
+		cli.StringFlag{
+			Name:   "exec-root",
+			Usage:  "Root directory for godocker execution state",
+			Value:  config.DefaultExecRoot,
+			EnvVar: config.EnvExecRoot,
+		},
+		cli.StringFlag{
+			Name:   "cgroup-driver",
+			Usage:  "Driver used to manage container cgroups (cgroupfs, systemd)",
+			Value:  config.DefaultCgroupDriver,
+			EnvVar: config.EnvCgroupDriver,
+		},
+	}
 	cliApp.Before = func(ctx *cli.Context) error {
 		logrus.SetReportCaller(true)
 		logrus.SetFormatter(&logrus.JSONFormatter{})
 		logrus.SetOutput(os.Stdout)
 		logrus.SetLevel(logrus.ErrorLevel)
 
+		if err := cgroup.ValidateDriver(ctx.GlobalString("cgroup-driver")); err != nil {
+			return err
+		}
+		config.Set(&config.Config{
+			Root:         ctx.GlobalString("root"),
+			ExecRoot:     ctx.GlobalString("exec-root"),
+			CgroupDriver: ctx.GlobalString("cgroup-driver"),
+		})
+
 		return nil
 	}
 	cliApp.Commands = []cli.Command{
 		initCommand,
+		shimCommand,
 		runCommand,
 		execCommand,
 		commitCommand,
 		listCommand,
 		logsCommand,
+		startCommand,
 		stopCommand,
+		killCommand,
+		restartCommand,
+		pauseCommand,
+		unpauseCommand,
+		waitCommand,
 		removeCommand,
+		inspectCommand,
 		containerCommand,
 		networkCommand,
+		imageCommand,
+		buildCommand,
+		volumeCommand,
+		loadCommand,
+		saveCommand,
 	}
 
 	return &Docker{cliApp: cliApp}
@@ -0,0 +1,294 @@
+package godocker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"godocker/internal/builder"
+	"godocker/internal/container"
+	"godocker/internal/image"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var imageCommand = cli.Command{
+	Name:  "image",
+	Usage: "Manage images",
+	Subcommands: []cli.Command{
+		{
+			Name:  "ls",
+			Usage: "List images",
+			Action: func(ctx *cli.Context) error {
+				return listImages()
+			},
+		},
+		{
+			Name:  "inspect",
+			Usage: "Display detailed information on one or more images",
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return fmt.Errorf("missing image name")
+				}
+
+				return inspectImages(ctx.Args())
+			},
+		},
+		{
+			Name:  "rm",
+			Usage: "Remove one or more images",
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return fmt.Errorf("missing image name")
+				}
+
+				store := image.NewStore()
+				for _, ref := range ctx.Args() {
+					if err := store.Remove(ref, container.ImageInUse); err != nil {
+						logrus.Errorf("Remove image %s error: %v", ref, err)
+						continue
+					}
+					fmt.Println(ref)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "history",
+			Usage: "Show the history of an image",
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return fmt.Errorf("missing image name")
+				}
+
+				return imageHistory(ctx.Args().First())
+			},
+		},
+		{
+			Name:      "tag",
+			Usage:     "Create a tag TARGET_IMAGE that refers to SOURCE_IMAGE",
+			ArgsUsage: "SOURCE_IMAGE[:TAG] TARGET_IMAGE[:TAG]",
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 2 {
+					return fmt.Errorf("missing source or target image")
+				}
+
+				store := image.NewStore()
+				img, err := store.Get(ctx.Args().Get(0))
+				if err != nil {
+					return err
+				}
+				return store.Tag(img.ID, ctx.Args().Get(1))
+			},
+		},
+		loadCommand,
+		saveCommand,
+	},
+}
+
+// sudo ./godocker build -f Dockerfile -t busybox:v1 .
+var buildCommand = cli.Command{
+	Name:  "build",
+	Usage: "Build an image from a Dockerfile",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file, f",
+			Usage: "Name of the Dockerfile (default: PATH/Dockerfile)",
+		},
+		cli.StringSliceFlag{
+			Name:  "tag, t",
+			Usage: "Name and optionally a tag in the 'name:tag' format",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "Do not use cache when building the image",
+		},
+		cli.StringFlag{
+			Name:  "storage-driver",
+			Usage: "Storage driver used by RUN instructions",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			return fmt.Errorf("build requires exactly 1 argument: PATH")
+		}
+
+		_, err := builder.Build(builder.Options{
+			Dockerfile:    ctx.String("file"),
+			ContextDir:    ctx.Args().Get(0),
+			Tags:          ctx.StringSlice("tag"),
+			NoCache:       ctx.Bool("no-cache"),
+			StorageDriver: ctx.String("storage-driver"),
+		})
+		return err
+	},
+}
+
+var loadCommand = cli.Command{
+	Name:  "load",
+	Usage: "Load an image from a docker save or OCI image layout tar archive",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "i",
+			Usage: "Read from tar archive file, instead of STDIN",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		input := os.Stdin
+		if file := ctx.String("i"); file != "" {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			input = f
+		}
+
+		refs, err := image.NewStore().Load(input)
+		for _, ref := range refs {
+			fmt.Printf("Loaded image: %s\n", ref)
+		}
+		return err
+	},
+}
+
+var saveCommand = cli.Command{
+	Name:  "save",
+	Usage: "Save one or more images to a tar archive",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o",
+			Usage: "Write to a file, instead of STDOUT",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "Archive format (docker, oci)",
+			Value: image.FormatDocker,
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing image name")
+		}
+
+		output := os.Stdout
+		if file := ctx.String("o"); file != "" {
+			f, err := os.Create(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			output = f
+		}
+
+		return image.NewStore().Save(ctx.Args(), ctx.String("format"), output)
+	},
+}
+
+func listImages() error {
+	store := image.NewStore()
+	images, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprintf(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\n")
+	for _, img := range images {
+		tags, err := store.Tags(img.ID)
+		if err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			// 没有 tag 的中间镜像（例如 build 的每一步）不展示
+			if children, err := store.HasChildren(img.ID); err != nil {
+				return err
+			} else if children {
+				continue
+			}
+			tags = []string{"<none>:<none>"}
+		}
+
+		for _, tag := range tags {
+			i := strings.LastIndex(tag, ":")
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n",
+				tag[:i],
+				tag[i+1:],
+				image.ShortID(img.ID),
+				img.Created.Format(time.RFC3339),
+				store.Size(img),
+			)
+		}
+	}
+
+	return w.Flush()
+}
+
+func imageHistory(ref string) error {
+	items, err := image.NewStore().History(ref)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprintf(w, "IMAGE\tLAYER\tCREATED\tCREATED BY\tSIZE\tCOMMENT\n")
+	for _, item := range items {
+		imageID, layer := "<missing>", "<empty>"
+		if item.ImageID != "" {
+			imageID = image.ShortID(item.ImageID)
+		}
+		if item.Layer != "" {
+			layer = image.ShortID(item.Layer)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			imageID,
+			layer,
+			item.Created.Format(time.RFC3339),
+			item.CreatedBy,
+			item.Size,
+			item.Comment,
+		)
+	}
+
+	return w.Flush()
+}
+
+func inspectImages(refs []string) error {
+	type imageInspect struct {
+		ID       string   `json:"Id"`
+		RepoTags []string `json:"RepoTags"`
+		Size     int64    `json:"Size"`
+		*image.Image
+	}
+
+	store := image.NewStore()
+	var result []imageInspect
+	for _, ref := range refs {
+		img, err := store.Get(ref)
+		if err != nil {
+			return err
+		}
+		tags, err := store.Tags(img.ID)
+		if err != nil {
+			return err
+		}
+		result = append(result, imageInspect{
+			ID:       img.ID,
+			RepoTags: tags,
+			Size:     store.Size(img),
+			Image:    img,
+		})
+	}
+
+	content, err := json.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(content))
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package godocker
+
+import (
+	"fmt"
+
+	"godocker/internal/container"
+	"godocker/internal/network"
+
+	"github.com/sirupsen/logrus"
+)
+
+// removeContainer 删除容器记录的所有资源：网络端点、挂载、读写层以及运行时目录。
+// force 为 true 时先停止运行中的容器。
+func removeContainer(name string, force bool) error {
+	info, err := container.GetContainerInfo(name)
+	if err != nil {
+		return fmt.Errorf("no such container: %s", name)
+	}
+
+	if info.Running() {
+		if !force {
+			return fmt.Errorf("you cannot remove a running container %s, stop the container before attempting removal or use -f", name)
+		}
+		if err := container.KillContainer(name); err != nil {
+			return err
+		}
+	}
+
+	// 释放后的端点立即写回 config.json，删除失败后再次 rm 不会重复释放已经分配给其他容器的 IP
+	if _, err := container.ModifyContainerInfo(name, func(latest *container.Info) error {
+		disconnectNetworks(latest)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return container.RemoveContainer(name)
+}
+
+// disconnectNetworks 释放容器在所有网络中的端点
+func disconnectNetworks(info *container.Info) {
+	if len(info.Networks) == 0 {
+		return
+	}
+
+	network.Init()
+	for _, endpoint := range info.Networks {
+		if err := network.DisconnectNetwork(endpoint.Network, info); err != nil {
+			logrus.Errorf("Disconnect container %s from network %s error: %v", info.Name, endpoint.Network, err)
+		}
+	}
+}
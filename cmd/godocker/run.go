@@ -1,58 +1,180 @@
 package godocker
 
 import (
+	"fmt"
+	"os/exec"
 	"strconv"
 
 	"godocker/internal/cgroup"
+	"godocker/internal/cgroup/subsystem"
 	"godocker/internal/container"
+	"godocker/internal/image"
 	"godocker/internal/network"
+	"godocker/pkg"
 
 	"github.com/sirupsen/logrus"
 )
 
 func Run(tty bool, comArray []string, opts ...container.Option) {
 	options := container.NewOptions().Apply(opts...)
-	parent, wPipe := container.NewParentProcess(tty, *options)
-	if err := parent.Start(); err != nil {
-		logrus.Errorf("Start parent procces error: %v", err)
+
+	// 使用镜像配置补全容器命令、环境变量和工作目录
+	if options.ImageID == "" {
+		logrus.Errorf("No such image: %s", options.Image)
+		return
+	}
+	img, err := image.NewStore().Get(options.ImageID)
+	if err != nil {
+		logrus.Errorf("Get image %s error: %v", options.Image, err)
 		return
 	}
+	comArray = img.Command(comArray)
+	if len(comArray) == 0 {
+		logrus.Errorf("No command specified")
+		return
+	}
+	options.Envs = append(append([]string{}, img.Config.Env...), options.Envs...)
+	options.WorkingDir = img.Config.WorkingDir
+	options.User = img.Config.User
+	options.StopSignal = img.Config.StopSignal
 
-	containerName, err := container.RecordContainerInfo(parent.Process.Pid, options.Name, comArray, options.Volume)
+	// 读写层和命名卷的引用都以容器名为标识，未指定名字时使用随机 ID
+	if options.Name == "" {
+		options.Name = pkg.RandStringBytes(10)
+	}
+	// 同名容器共用读写层和 config.json，名字已经被使用时拒绝创建
+	if container.ContainerExists(options.Name) {
+		logrus.Errorf("Conflict. The container name %s is already in use", options.Name)
+		return
+	}
+
+	// 后台运行的容器由 shim 创建并等待退出，CLI 在容器启动后直接返回
+	if !tty {
+		containerName, err := startShim(&shimRequest{Args: comArray, Options: options})
+		if err != nil {
+			logrus.Errorf("Run container error: %v", err)
+			return
+		}
+		fmt.Println(containerName)
+		return
+	}
+
+	parent, containerName, err := createContainer(tty, comArray, options, nil)
+	if containerName != "" {
+		defer func() {
+			// 出错返回时容器进程可能仍在运行，一并停止，容器的 cgroup 随容器一起删除
+			if err := removeContainer(containerName, true); err != nil {
+				logrus.Errorf("Remove container %s error: %v", containerName, err)
+			}
+		}()
+	}
 	if err != nil {
-		logrus.Errorf("Record container information error: %v", err)
+		logrus.Errorf("Create container error: %v", err)
 		return
 	}
 
-	cGroupManager := cgroup.NewCGroup("godocker.slice")
+	_ = parent.Wait()
+}
+
+// createContainer 启动容器进程，完成 cgroup、网络设置后发送 init 配置。
+// info 为 nil 时记录新的容器信息，否则复用已有的容器信息重新启动容器。
+// 返回的名字不为空时容器信息已经记录，出错时由调用方清理容器。
+func createContainer(tty bool, comArray []string, options *container.Options, info *container.Info) (parent *exec.Cmd, containerName string, err error) {
+	parent, wPipe := container.NewParentProcess(tty, *options)
+	if parent == nil {
+		return nil, "", fmt.Errorf("new parent process error")
+	}
 	defer func() {
-		if tty {
-			_ = cGroupManager.Destroy()
-			// volume imageName containerName
-			container.RemoveWorkSpace(options.Volume, options.Name)
-			container.RemoveContainerInfo(containerName)
+		// 没有发送 init 配置时关闭管道，容器 init 进程读取失败后退出
+		if err != nil {
+			_ = wPipe.Close()
 		}
 	}()
-	_ = cGroupManager.Set(options.ResourceConfig)
-	_ = cGroupManager.Apply(parent.Process.Pid)
+	if err := parent.Start(); err != nil {
+		if info == nil {
+			removeWorkSpace(options)
+		}
+		return nil, "", fmt.Errorf("start parent procces error: %v", err)
+	}
 
-	if options.Network != "" {
-		network.Init()
-		containerInfo := &container.Info{
-			ID:          containerName,
-			Pid:         strconv.Itoa(parent.Process.Pid),
-			Name:        containerName,
-			PortMapping: options.PortMapping,
+	if info == nil {
+		info, err = container.RecordContainerInfo(parent.Process.Pid, comArray, *options)
+		if err != nil {
+			_ = parent.Process.Kill()
+			_ = parent.Wait()
+			removeWorkSpace(options)
+			return nil, "", fmt.Errorf("record container information error: %v", err)
 		}
-		if err := network.ConnectNetwork(options.Network, containerInfo); err != nil {
-			logrus.Errorf("Error Connect Network %v", err)
-			return
+		containerName = info.Name
+	} else {
+		containerName = info.Name
+		info, err = container.ModifyContainerInfo(containerName, func(latest *container.Info) error {
+			// 启动期间容器被 stop 停止，由调用方杀死刚启动的进程
+			if latest.ManuallyStopped {
+				return fmt.Errorf("container %s has been stopped", containerName)
+			}
+			latest.Pid = strconv.Itoa(parent.Process.Pid)
+			latest.Status = container.Running
+			// 旧版本创建的容器没有自己的 cgroup，重新启动时按 cgroupfs 分配
+			if latest.CgroupPath == "" {
+				latest.CgroupPath, _ = cgroup.ContainerPath(cgroup.DriverCgroupfs, options.CgroupParent, latest.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			_ = parent.Process.Kill()
+			return parent, containerName, err
+		}
+	}
+
+	// 每个容器使用自己的 cgroup，删除容器时一起删除
+	cGroupManager := info.CGroup()
+	res := options.ResourceConfig
+	if res == nil {
+		res = &subsystem.ResourceConfig{}
+	}
+	if err := cGroupManager.Set(res); err != nil {
+		logrus.Errorf("Set container %s cgroup error %v", containerName, err)
+	}
+	if err := cGroupManager.Apply(parent.Process.Pid); err != nil {
+		logrus.Errorf("Apply container %s cgroup error %v", containerName, err)
+	}
+
+	if options.Network != "" {
+		if err := connectNetwork(containerName, options.Network); err != nil {
+			return parent, containerName, fmt.Errorf("connect network error: %v", err)
 		}
 	}
 
-	container.WriteInitCommand(comArray, wPipe)
+	container.WriteInitConfig(&container.InitConfig{
+		Args:       comArray,
+		Hostname:   options.Hostname,
+		WorkingDir: options.WorkingDir,
+		User:       options.User,
+		Mounts:     options.Mounts,
+		ReadOnly:   options.ReadOnly,
+	}, wPipe)
+
+	return parent, containerName, nil
+}
+
+// removeWorkSpace 新容器的信息记录之前出错时调用方无法删除容器，在这里删除读写层并释放卷
+func removeWorkSpace(options *container.Options) {
+	container.RemoveWorkSpace(options.Mounts, options.Name, options.StorageDriver)
+}
 
-	if tty {
-		_ = parent.Wait()
+// connectNetwork 将容器接入网络，分配的 IP 和 veth 记录在容器信息中，删除容器时释放
+func connectNetwork(containerName, networkName string) error {
+	network.Init()
+	var connectErr error
+	_, err := container.ModifyContainerInfo(containerName, func(containerInfo *container.Info) error {
+		// 接入失败时也记录已经分配的资源，删除容器时释放
+		connectErr = network.ConnectNetwork(networkName, containerInfo)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
+
+	return connectErr
 }
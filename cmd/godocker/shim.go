@@ -0,0 +1,169 @@
+package godocker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"godocker/internal/cgroup"
+	"godocker/internal/container"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// shimRequest CLI 通过管道发送给 shim 的参数。Options 为空时重新启动名为 Name 的已有容器。
+type shimRequest struct {
+	Name    string             `json:"name,omitempty"`
+	Args    []string           `json:"args,omitempty"`
+	Options *container.Options `json:"options,omitempty"`
+}
+
+// shimResponse 容器启动完成后 shim 返回给 CLI 的结果
+type shimResponse struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+var shimCommand = cli.Command{
+	Name:   "shim",
+	Usage:  "Monitor a detached container until it exits",
+	Hidden: true,
+	Action: func(ctx *cli.Context) error {
+		return runShim()
+	},
+}
+
+// startShim 启动 shim 进程并等待容器创建完成。
+// shim 在独立的会话中运行，是容器 init 进程的父进程，持有容器日志文件，CLI 退出后继续等待容器退出并记录退出状态。
+func startShim(request *shimRequest) (string, error) {
+	reqReader, reqWriter, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	respReader, respWriter, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	defer respReader.Close()
+
+	cmd := exec.Command("/proc/self/exe", "shim")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	cmd.ExtraFiles = []*os.File{reqReader, respWriter} // fd 3 4
+	cmd.Dir = "/"
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("start shim error: %v", err)
+	}
+	_ = reqReader.Close()
+	_ = respWriter.Close()
+
+	err = json.NewEncoder(reqWriter).Encode(request)
+	_ = reqWriter.Close()
+	if err != nil {
+		return "", fmt.Errorf("send request to shim error: %v", err)
+	}
+
+	var resp shimResponse
+	if err := json.NewDecoder(respReader).Decode(&resp); err != nil {
+		_ = cmd.Wait()
+		return "", fmt.Errorf("shim exited before the container started: %v", err)
+	}
+	_ = cmd.Process.Release()
+
+	if resp.Error != "" {
+		return resp.Name, errors.New(resp.Error)
+	}
+
+	return resp.Name, nil
+}
+
+// runShim 创建容器并一直等待容器 init 进程退出，退出后把退出码、是否被 OOM killer 杀死以及退出时间写入容器信息，
+// 并按重启策略重新启动容器
+func runShim() error {
+	reqReader := os.NewFile(uintptr(3), "request")
+	respWriter := os.NewFile(uintptr(4), "response")
+
+	var request shimRequest
+	err := json.NewDecoder(reqReader).Decode(&request)
+	_ = reqReader.Close()
+	if err != nil {
+		return fmt.Errorf("read shim request error: %v", err)
+	}
+
+	var parent *exec.Cmd
+	containerName := request.Name
+	if request.Options == nil {
+		parent, err = startContainer(containerName, false)
+	} else {
+		parent, containerName, err = createContainer(false, request.Args, request.Options, nil)
+		if err != nil && containerName != "" {
+			if err := removeContainer(containerName, true); err != nil {
+				logrus.Errorf("Remove container %s error: %v", containerName, err)
+			}
+		}
+	}
+
+	resp := shimResponse{Name: containerName}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	_ = json.NewEncoder(respWriter).Encode(&resp)
+	_ = respWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	info, err := container.GetContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	cGroupManager := info.CGroup()
+
+	// 容器退出后按重启策略重新启动，被 stop/kill 停止或者被删除的容器不再重启
+	var delay time.Duration
+	for {
+		startedAt := time.Now()
+		exitCode, oomKilled := waitExit(parent, cGroupManager)
+		if err := container.RecordExit(containerName, parent.Process.Pid, exitCode, oomKilled); err != nil {
+			return err
+		}
+
+		info, err := container.GetContainerInfo(containerName)
+		if err != nil {
+			return err
+		}
+		if !info.Options().RestartPolicy.ShouldRestart(exitCode, info.RestartCount, info.ManuallyStopped) {
+			return nil
+		}
+
+		delay = container.NextRestartDelay(delay, time.Since(startedAt))
+		time.Sleep(delay)
+		if parent, err = startContainer(containerName, true); err != nil {
+			return err
+		}
+	}
+}
+
+// waitExit 等待容器 init 进程退出，被信号杀死时退出码为 128+信号
+func waitExit(parent *exec.Cmd, cGroupManager *cgroup.CGroup) (int, bool) {
+	// 容器被 SIGKILL 杀死并且期间发生过 OOM kill 时认为是 OOM
+	oomKilled := cGroupManager.WatchOOMKill()
+
+	_ = parent.Wait()
+	status, _ := parent.ProcessState.Sys().(syscall.WaitStatus)
+
+	// 无论是否被 SIGKILL 杀死都要停止监听
+	killed := oomKilled()
+
+	exitCode := status.ExitStatus()
+	if status.Signaled() {
+		exitCode = 128 + int(status.Signal())
+		return exitCode, killed && status.Signal() == syscall.SIGKILL
+	}
+
+	return exitCode, false
+}
@@ -0,0 +1,74 @@
+package godocker
+
+import (
+	"fmt"
+	"os/exec"
+	"time"
+
+	"godocker/internal/container"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Start 使用保存的配置重新启动已停止的容器，容器由 shim 创建，见 startContainer
+func Start(name string) error {
+	_, err := startShim(&shimRequest{Name: name})
+	return err
+}
+
+// startContainer 复用原有的读写层，重新创建 namespace 和 cgroup 并接入网络。
+// restart 为 true 时是 shim 按重启策略重新启动，否则是手动 start，重启次数清零。
+func startContainer(name string, restart bool) (*exec.Cmd, error) {
+	info, err := container.ModifyContainerInfo(name, func(latest *container.Info) error {
+		if latest.Running() {
+			return fmt.Errorf("container %s is already running", name)
+		}
+		// 旧版本迁移过来的容器没有记录镜像和参数
+		if latest.ImageID == "" || len(latest.Args) == 0 {
+			return fmt.Errorf("container %s has no saved command, it can't be started", name)
+		}
+		if restart {
+			// 等待重启期间容器被 stop 停止
+			if latest.ManuallyStopped {
+				return fmt.Errorf("container %s has been stopped", name)
+			}
+			latest.RestartCount++
+		} else {
+			latest.RestartCount = 0
+		}
+		latest.ManuallyStopped = false
+
+		// 上一次运行分配的 veth 已经随着网络空间销毁，释放 IP 和端口映射后重新分配
+		disconnectNetworks(latest)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	options := info.Options()
+	parent, _, err := createContainer(false, info.Args, &options, info)
+	if err != nil && parent != nil {
+		if err := container.KillContainer(name); err != nil {
+			logrus.Errorf("Kill container %s error: %v", name, err)
+		}
+	}
+
+	return parent, err
+}
+
+// Restart 停止运行中的容器后重新启动，timeout 为停止时等待容器退出的时间
+func Restart(name string, timeout time.Duration) error {
+	info, err := container.GetContainerInfo(name)
+	if err != nil {
+		return fmt.Errorf("no such container: %s", name)
+	}
+
+	if info.Running() {
+		if err := container.StopContainer(name, timeout); err != nil {
+			return err
+		}
+	}
+
+	return Start(name)
+}
@@ -0,0 +1,148 @@
+package godocker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"godocker/internal/volume"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// sudo ./godocker volume create data
+// sudo ./godocker run -it -v data:/data busybox sh
+var volumeCommand = cli.Command{
+	Name:  "volume",
+	Usage: "Manage volumes",
+	Subcommands: []cli.Command{
+		{
+			Name:      "create",
+			Usage:     "Create a volume",
+			ArgsUsage: "[VOLUME]",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "label",
+					Usage: "Set metadata for a volume (key=value)",
+				},
+			},
+			Action: func(ctx *cli.Context) error {
+				labels, err := parseLabels(ctx.StringSlice("label"))
+				if err != nil {
+					return err
+				}
+
+				v, err := volume.NewStore().Create(ctx.Args().First(), labels)
+				if err != nil {
+					return err
+				}
+				fmt.Println(v.Name)
+				return nil
+			},
+		},
+		{
+			Name:  "ls",
+			Usage: "List volumes",
+			Action: func(ctx *cli.Context) error {
+				return listVolumes()
+			},
+		},
+		{
+			Name:  "inspect",
+			Usage: "Display detailed information on one or more volumes",
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return fmt.Errorf("missing volume name")
+				}
+
+				store := volume.NewStore()
+				var volumes []*volume.Volume
+				for _, name := range ctx.Args() {
+					v, err := store.Get(name)
+					if err != nil {
+						return err
+					}
+					volumes = append(volumes, v)
+				}
+
+				content, err := json.MarshalIndent(volumes, "", "    ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(content))
+				return nil
+			},
+		},
+		{
+			Name:  "rm",
+			Usage: "Remove one or more volumes",
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return fmt.Errorf("missing volume name")
+				}
+
+				store := volume.NewStore()
+				for _, name := range ctx.Args() {
+					if err := store.Remove(name); err != nil {
+						logrus.Errorf("Remove volume %s error: %v", name, err)
+						continue
+					}
+					fmt.Println(name)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "Remove all unused volumes",
+			Action: func(ctx *cli.Context) error {
+				removed, reclaimed, err := volume.NewStore().Prune()
+				if len(removed) > 0 {
+					fmt.Println("Deleted Volumes:")
+					for _, name := range removed {
+						fmt.Println(name)
+					}
+					fmt.Println()
+				}
+				fmt.Printf("Total reclaimed space: %d\n", reclaimed)
+				return err
+			},
+		},
+	},
+}
+
+func listVolumes() error {
+	volumes, err := volume.NewStore().List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprintf(w, "DRIVER\tVOLUME NAME\tREFS\n")
+	for _, v := range volumes {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", v.Driver, v.Name, len(v.Refs))
+	}
+
+	return w.Flush()
+}
+
+// parseLabels 解析 --label key=value
+func parseLabels(labels []string) (map[string]string, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+
+	result := make(map[string]string, len(labels))
+	for _, label := range labels {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid label %s", label)
+		}
+		result[kv[0]] = kv[1]
+	}
+
+	return result, nil
+}
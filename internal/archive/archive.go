@@ -0,0 +1,401 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// DecompressStream 根据文件头自动识别 gzip 压缩的 tar 流
+func DecompressStream(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("open gzip stream error: %v", err)
+		}
+		return gz, nil
+	}
+
+	return ioutil.NopCloser(br), nil
+}
+
+// Untar 将 tar 流解压到 dest
+func Untar(r io.Reader, dest string) error {
+	return untar(r, dest, false)
+}
+
+// ApplyLayer 将镜像层的 tar 流解压到 dest，并把 .wh. 形式的 whiteout 转换为 overlay 格式
+func ApplyLayer(r io.Reader, dest string) error {
+	return untar(r, dest, true)
+}
+
+func untar(r io.Reader, dest string, layer bool) error {
+	rc, err := DecompressStream(r)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// 目录的时间戳需要在其内容解压完成之后再设置
+	var dirs []*tar.Header
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar header error: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		target, err := scopedPath(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if target == dest {
+			continue
+		}
+
+		// 父目录不一定出现在 tar 包中
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		if layer {
+			if ok, err := applyWhiteout(target); ok || err != nil {
+				if err != nil {
+					return fmt.Errorf("apply whiteout %s error: %v", hdr.Name, err)
+				}
+				continue
+			}
+		}
+
+		if err := createEntry(tr, hdr, dest, target); err != nil {
+			return fmt.Errorf("extract %s error: %v", hdr.Name, err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, hdr)
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target, err := scopedPath(dest, dirs[i].Name)
+		if err != nil {
+			return err
+		}
+		if err := setTimes(target, dirs[i]); err != nil {
+			return fmt.Errorf("extract %s error: %v", dirs[i].Name, err)
+		}
+	}
+
+	return nil
+}
+
+// scopedPath 计算 tar 条目在 dest 中的路径。条目名中的 .. 以及路径中已解压的符号链接
+// 都不允许指向 dest 之外，否则恶意的 tar 包可以覆盖主机上的文件。
+func scopedPath(dest, name string) (string, error) {
+	rel := filepath.Clean(strings.TrimPrefix(name, "/"))
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("invalid tar entry %s: path traversal is not allowed", name)
+	}
+	if rel == "." {
+		return dest, nil
+	}
+
+	// 最后一个元素本身可以是符号链接（会被覆盖），只需要解析父目录
+	parent, err := ResolveInScope(dest, filepath.Dir(rel))
+	if err != nil {
+		return "", fmt.Errorf("invalid tar entry %s: %v", name, err)
+	}
+
+	return filepath.Join(parent, filepath.Base(rel)), nil
+}
+
+// ResolveInScope 在 root 中逐级解析 p，遇到符号链接时和 chroot 中一样，
+// 绝对路径的目标相对于 root 解析，根目录的 .. 仍然是根目录，因此结果不会离开 root
+func ResolveInScope(root, p string) (string, error) {
+	const maxLinks = 255
+
+	resolved := "/"
+	remaining := strings.Split(strings.TrimPrefix(filepath.Clean("/"+p), "/"), "/")
+	for links := 0; len(remaining) > 0; {
+		elem := remaining[0]
+		remaining = remaining[1:]
+		if elem == "" || elem == "." {
+			continue
+		}
+		if elem == ".." {
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, elem)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			// 不存在的路径会在之后被创建为目录
+			resolved = next
+			continue
+		}
+
+		if links++; links > maxLinks {
+			return "", fmt.Errorf("too many symlinks in %s", p)
+		}
+		link, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(link) {
+			resolved = "/"
+		}
+		remaining = append(strings.Split(link, "/"), remaining...)
+	}
+
+	return filepath.Join(root, resolved), nil
+}
+
+func createEntry(tr *tar.Reader, hdr *tar.Header, dest, target string) error {
+	// 目录以外的文件覆盖已有的同名文件
+	if hdr.Typeflag != tar.TypeDir {
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+
+	mode := os.FileMode(hdr.Mode).Perm()
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+		if err := os.Mkdir(target, mode); err != nil && !os.IsExist(err) {
+			return err
+		}
+
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+
+	case tar.TypeLink:
+		// 硬链接的源文件同样不允许在 dest 之外
+		source, err := scopedPath(dest, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		// 硬链接与源文件共享 inode，不需要再设置属性
+		return os.Link(source, target)
+
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		fileType := uint32(unix.S_IFIFO)
+		switch hdr.Typeflag {
+		case tar.TypeChar:
+			fileType = unix.S_IFCHR
+		case tar.TypeBlock:
+			fileType = unix.S_IFBLK
+		}
+		dev := int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor)))
+		if err := unix.Mknod(target, fileType|uint32(mode), dev); err != nil {
+			return fmt.Errorf("mknod error: %v", err)
+		}
+
+	default:
+		return fmt.Errorf("unsupported tar entry type %c", hdr.Typeflag)
+	}
+
+	return setMetadata(target, hdr)
+}
+
+// setMetadata 设置属主、权限位（包括 setuid/setgid/sticky）、扩展属性以及时间戳，
+// 非 root 用户无法修改属主时保留当前用户。目录的时间戳在 untar 的最后设置。
+func setMetadata(target string, hdr *tar.Header) error {
+	if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil && !os.IsPermission(err) {
+		return fmt.Errorf("lchown error: %v", err)
+	}
+
+	// chown 会清除 setuid/setgid，所以权限在属主之后设置
+	if hdr.Typeflag != tar.TypeSymlink {
+		if err := unix.Chmod(target, uint32(hdr.Mode)&07777); err != nil {
+			return fmt.Errorf("chmod error: %v", err)
+		}
+	}
+
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, paxXattrPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, paxXattrPrefix)
+		if err := unix.Lsetxattr(target, name, []byte(value), 0); err != nil {
+			if err == unix.ENOTSUP || err == unix.EPERM {
+				continue
+			}
+			return fmt.Errorf("set xattr %s error: %v", name, err)
+		}
+	}
+
+	if hdr.Typeflag == tar.TypeDir {
+		return nil
+	}
+
+	return setTimes(target, hdr)
+}
+
+func setTimes(target string, hdr *tar.Header) error {
+	atime := hdr.AccessTime
+	if atime.IsZero() {
+		atime = hdr.ModTime
+	}
+	ts := []unix.Timespec{
+		unix.NsecToTimespec(atime.UnixNano()),
+		unix.NsecToTimespec(hdr.ModTime.UnixNano()),
+	}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, target, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return fmt.Errorf("set times error: %v", err)
+	}
+
+	return nil
+}
+
+// Tar 将 src 目录打包为 tar 流
+func Tar(src string, w io.Writer) error {
+	return tarDir(src, w, false)
+}
+
+// TarLayer 将镜像层目录打包为 tar 流，并把 overlay 格式的 whiteout 转换为 .wh. 文件
+func TarLayer(src string, w io.Writer) error {
+	return tarDir(src, w, true)
+}
+
+func tarDir(src string, w io.Writer, layer bool) error {
+	tw := tar.NewWriter(w)
+	// 硬链接: inode -> 第一次出现的路径
+	links := make(map[uint64]string)
+	err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		// tar 不能表示 socket，和 docker 一样跳过
+		if info.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+
+		if layer {
+			if ok, err := writeWhiteout(tw, p, rel, info); ok || err != nil {
+				return err
+			}
+		}
+
+		if err := writeEntry(tw, p, rel, info, links); err != nil {
+			return fmt.Errorf("tar %s error: %v", p, err)
+		}
+
+		if layer && info.IsDir() {
+			return writeOpaque(tw, p, rel)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+func writeEntry(tw *tar.Writer, p, rel string, info os.FileInfo, links map[uint64]string) error {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(p)
+		if err != nil {
+			return err
+		}
+		link = target
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = rel
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+
+	xattrs, err := ListXattrs(p)
+	if err != nil {
+		return err
+	}
+	for name, value := range xattrs {
+		if isOverlayXattr(name) {
+			continue
+		}
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string)
+		}
+		hdr.PAXRecords[paxXattrPrefix+name] = string(value)
+	}
+
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode().IsRegular() && st.Nlink > 1 {
+		if first, ok := links[st.Ino]; ok {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = first
+			hdr.Size = 0
+		} else {
+			links[st.Ino] = rel
+		}
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(tw, f)
+	return err
+}
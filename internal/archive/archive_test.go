@@ -0,0 +1,223 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestUntarTraversal(t *testing.T) {
+	outside := t.TempDir()
+	cases := map[string][]*tar.Header{
+		"dotdot": {
+			{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+		"absolute dotdot": {
+			{Name: "/../../evil", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+		"hardlink": {
+			{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"},
+		},
+	}
+	for name, headers := range cases {
+		dest := t.TempDir()
+		if err := Untar(bytes.NewReader(writeTar(t, headers)), dest); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// 符号链接指向 dest 之外时，经过符号链接写入的文件仍然留在 dest 中
+	dest := t.TempDir()
+	headers := []*tar.Header{
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside},
+		{Name: "link/evil", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "rel", Typeflag: tar.TypeSymlink, Linkname: "../../../../.."},
+		{Name: "rel/evil2", Typeflag: tar.TypeReg, Mode: 0644},
+	}
+	if err := Untar(bytes.NewReader(writeTar(t, headers)), dest); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"evil", "evil2"} {
+		if _, err := os.Lstat(path.Join(outside, name)); err == nil {
+			t.Errorf("%s escaped from dest", name)
+		}
+	}
+	if _, err := os.Lstat(path.Join(dest, outside, "evil")); err != nil {
+		t.Errorf("evil should be extracted in dest: %v", err)
+	}
+	if _, err := os.Lstat(path.Join(dest, "evil2")); err != nil {
+		t.Errorf("evil2 should be extracted in dest: %v", err)
+	}
+}
+
+func TestTarRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.MkdirAll(path.Join(src, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	busybox := path.Join(src, "bin", "busybox")
+	if err := os.WriteFile(busybox, []byte("busybox"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(busybox, 0755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	xattr := unix.Lsetxattr(busybox, "user.godocker", []byte("test"), 0) == nil
+	if err := os.Chtimes(busybox, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(path.Join(src, "fifo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path.Join(src, "bin"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	root := os.Geteuid() == 0
+	if root {
+		if err := os.Lchown(busybox, 1000, 1000); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(busybox, 0755|os.ModeSetuid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 打包后用 gzip 压缩，解压时应该自动识别
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := Tar(src, gz); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := t.TempDir()
+	if err := Untar(&buf, dest); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Lstat(path.Join(dest, "bin", "busybox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0755|os.ModeSetuid {
+		t.Errorf("mode of busybox: %v", info.Mode())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("mtime of busybox: %v", info.ModTime())
+	}
+	if root {
+		if st := info.Sys().(*syscall.Stat_t); st.Uid != 1000 || st.Gid != 1000 {
+			t.Errorf("owner of busybox: %d:%d", st.Uid, st.Gid)
+		}
+	}
+	if xattr {
+		value := make([]byte, 4)
+		if n, err := unix.Lgetxattr(path.Join(dest, "bin", "busybox"), "user.godocker", value); err != nil || string(value[:n]) != "test" {
+			t.Errorf("xattr of busybox: %s, %v", value, err)
+		}
+	}
+	if info, err := os.Lstat(path.Join(dest, "bin")); err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("mtime of bin: %v, %v", info.ModTime(), err)
+	}
+	if info, err := os.Lstat(path.Join(dest, "fifo")); err != nil || info.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("fifo: %v", err)
+	}
+}
+
+func TestTarSkipSocket(t *testing.T) {
+	src := t.TempDir()
+	l, err := net.Listen("unix", path.Join(src, "docker.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := os.WriteFile(path.Join(src, "file"), []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tarFunc := range []func(string, io.Writer) error{Tar, TarLayer} {
+		var buf bytes.Buffer
+		if err := tarFunc(src, &buf); err != nil {
+			t.Fatalf("tar with socket: %v", err)
+		}
+
+		var names []string
+		tr := tar.NewReader(&buf)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, hdr.Name)
+		}
+		if len(names) != 1 || names[0] != "file" {
+			t.Errorf("unexpected entries %v", names)
+		}
+	}
+}
+
+func writeTar(t *testing.T, headers []*tar.Header) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range headers {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestResolveInScope(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(path.Join(root, "usr/share"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"data":  "/etc",
+		"up":    "../../..",
+		"share": "usr/share",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, path.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]string{
+		"/data":        path.Join(root, "etc"),
+		"/up/etc":      path.Join(root, "etc"),
+		"/share/doc":   path.Join(root, "usr/share/doc"),
+		"/../../a/b/c": path.Join(root, "a/b/c"),
+	}
+	for p, expected := range tests {
+		if resolved, err := ResolveInScope(root, p); err != nil || resolved != expected {
+			t.Errorf("resolve %s: expected %s, got %s, %v", p, expected, resolved, err)
+		}
+	}
+
+	if err := os.Symlink("loop", path.Join(root, "loop")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResolveInScope(root, "/loop"); err == nil {
+		t.Error("expected error for symlink loop")
+	}
+}
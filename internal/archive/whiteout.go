@@ -0,0 +1,88 @@
+package archive
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// 镜像层中用 .wh.<name> 表示删除了下层的 <name>，用 .wh..wh..opq 表示目录下层的内容全部不可见。
+// overlay 中对应的是 0/0 的字符设备以及目录上的 trusted.overlay.opaque=y 扩展属性。
+const (
+	WhiteoutPrefix     = ".wh."
+	WhiteoutOpaqueDir  = ".wh..wh..opq"
+	OverlayOpaqueXattr = "trusted.overlay.opaque"
+)
+
+// applyWhiteout 如果 target 是 whiteout 文件，则在所在目录中创建对应的 overlay whiteout
+func applyWhiteout(target string) (bool, error) {
+	dir, base := filepath.Split(target)
+	if base == WhiteoutOpaqueDir {
+		return true, unix.Lsetxattr(dir, OverlayOpaqueXattr, []byte("y"), 0)
+	}
+
+	if strings.HasPrefix(base, WhiteoutPrefix) {
+		original := filepath.Join(dir, strings.TrimPrefix(base, WhiteoutPrefix))
+		if err := os.RemoveAll(original); err != nil {
+			return true, err
+		}
+		return true, unix.Mknod(original, unix.S_IFCHR, 0)
+	}
+
+	return false, nil
+}
+
+// IsWhiteout 判断文件是否为 overlay 格式的 whiteout，即 0/0 的字符设备
+func IsWhiteout(info os.FileInfo) bool {
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
+
+// IsOpaque 判断目录是否设置了 overlay opaque 属性
+func IsOpaque(dir string) bool {
+	value := make([]byte, 1)
+	n, err := unix.Lgetxattr(dir, OverlayOpaqueXattr, value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
+
+// writeWhiteout 将 overlay whiteout 写为 .wh.<name> 空文件
+func writeWhiteout(tw *tar.Writer, p, rel string, info os.FileInfo) (bool, error) {
+	if !IsWhiteout(info) {
+		return false, nil
+	}
+
+	dir, base := filepath.Split(rel)
+	return true, tw.WriteHeader(&tar.Header{
+		Name:     filepath.Join(dir, WhiteoutPrefix+base),
+		Typeflag: tar.TypeReg,
+		Mode:     0600,
+		ModTime:  info.ModTime(),
+	})
+}
+
+// writeOpaque 在 opaque 目录中写入 .wh..wh..opq 文件
+func writeOpaque(tw *tar.Writer, p, rel string) error {
+	if !IsOpaque(p) {
+		return nil
+	}
+
+	info, err := os.Lstat(p)
+	if err != nil {
+		return err
+	}
+
+	return tw.WriteHeader(&tar.Header{
+		Name:     filepath.Join(rel, WhiteoutOpaqueDir),
+		Typeflag: tar.TypeReg,
+		Mode:     0600,
+		ModTime:  info.ModTime(),
+	})
+}
@@ -0,0 +1,55 @@
+package archive
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// tar 中扩展属性保存在 PAX 记录 SCHILY.xattr.<name> 中
+const paxXattrPrefix = "SCHILY.xattr."
+
+// ListXattrs 读取文件的全部扩展属性，文件系统不支持扩展属性时返回空
+func ListXattrs(p string) (map[string][]byte, error) {
+	size, err := unix.Llistxattr(p, nil)
+	if err != nil {
+		if err == unix.ENOTSUP {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("list xattr of %s error: %v", p, err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(p, buf)
+	if err != nil {
+		return nil, fmt.Errorf("list xattr of %s error: %v", p, err)
+	}
+
+	xattrs := make(map[string][]byte)
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if name == "" {
+			continue
+		}
+
+		valueSize, err := unix.Lgetxattr(p, name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("get xattr %s of %s error: %v", name, p, err)
+		}
+		value := make([]byte, valueSize)
+		if _, err := unix.Lgetxattr(p, name, value); err != nil {
+			return nil, fmt.Errorf("get xattr %s of %s error: %v", name, p, err)
+		}
+		xattrs[name] = value
+	}
+
+	return xattrs, nil
+}
+
+// isOverlayXattr overlay 自身使用的扩展属性不写入 tar 包，opaque 目录由 .wh..wh..opq 表示
+func isOverlayXattr(name string) bool {
+	return strings.HasPrefix(name, "trusted.overlay.")
+}
@@ -0,0 +1,203 @@
+package builder
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"godocker/internal/container"
+	"godocker/internal/image"
+	"godocker/pkg"
+)
+
+// Options build 的参数
+type Options struct {
+	Dockerfile    string   // Dockerfile 路径，为空时使用构建上下文中的 Dockerfile
+	ContextDir    string   // 构建上下文目录，COPY/ADD 的源路径相对于该目录
+	Tags          []string // 构建成功后打上的 name:tag
+	NoCache       bool
+	StorageDriver string // RUN 使用的存储驱动，为空时使用默认驱动
+	Output        io.Writer
+}
+
+// Builder 按顺序执行 Dockerfile 指令，每一步生成一个中间镜像
+type Builder struct {
+	opts  Options
+	store *image.Store
+	image *image.Image // 当前步骤的父镜像
+}
+
+// Build 构建镜像，返回最终的镜像 ID
+func Build(opts Options) (string, error) {
+	if opts.Output == nil {
+		opts.Output = os.Stdout
+	}
+	if opts.Dockerfile == "" {
+		opts.Dockerfile = opts.ContextDir + "/Dockerfile"
+	}
+
+	f, err := os.Open(opts.Dockerfile)
+	if err != nil {
+		return "", err
+	}
+	instructions, err := Parse(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	b := &Builder{opts: opts, store: image.NewStore()}
+	for i, instruction := range instructions {
+		fmt.Fprintf(opts.Output, "Step %d/%d : %s\n", i+1, len(instructions), instruction)
+		if err := b.dispatch(instruction); err != nil {
+			return "", fmt.Errorf("step %d/%d %s: %v", i+1, len(instructions), instruction.Cmd, err)
+		}
+		if b.image.ID != "" {
+			fmt.Fprintf(opts.Output, " ---> %s\n", image.ShortID(b.image.ID))
+		}
+	}
+	if b.image.ID == "" {
+		return "", fmt.Errorf("no image was generated, is your Dockerfile empty?")
+	}
+
+	fmt.Fprintf(opts.Output, "Successfully built %s\n", image.ShortID(b.image.ID))
+	for _, tag := range opts.Tags {
+		if err := b.store.Tag(b.image.ID, tag); err != nil {
+			return "", err
+		}
+		name, _ := image.NormalizeReference(tag)
+		fmt.Fprintf(opts.Output, "Successfully tagged %s\n", name)
+	}
+
+	return b.image.ID, nil
+}
+
+func (b *Builder) dispatch(instruction *Instruction) error {
+	switch instruction.Cmd {
+	case "FROM":
+		return b.from(instruction.Args)
+	case "RUN":
+		return b.run(instruction)
+	case "COPY":
+		return b.copy(instruction, false)
+	case "ADD":
+		return b.copy(instruction, true)
+	default:
+		// ENV WORKDIR CMD ENTRYPOINT USER EXPOSE LABEL STOPSIGNAL 只修改运行配置，不产生新的层
+		return b.commit(instruction, "/bin/sh -c #(nop) "+instruction.String(), nil, nil)
+	}
+}
+
+func (b *Builder) from(ref string) error {
+	if strings.Contains(strings.ToUpper(ref), " AS ") {
+		return fmt.Errorf("multi-stage builds are not supported")
+	}
+
+	if ref == "scratch" {
+		b.image = &image.Image{
+			Created:      time.Now().UTC(),
+			Architecture: runtime.GOARCH,
+			OS:           runtime.GOOS,
+			RootFS:       image.RootFS{Type: "layers"},
+		}
+		return nil
+	}
+
+	img, err := b.store.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	b.image = img
+
+	return nil
+}
+
+// run 在一次性容器中执行命令，并将容器读写层的变化提交为新的层
+func (b *Builder) run(instruction *Instruction) error {
+	args := image.ParseCommand(instruction.Args)
+	createdBy := strings.Join(args, " ")
+
+	return b.commit(instruction, createdBy, nil, func(parent *image.Image) (*image.Layer, error) {
+		name := "build-" + pkg.RandStringBytes(10)
+		options := container.Options{
+			Name:          name,
+			ImageID:       parent.ID,
+			StorageDriver: b.opts.StorageDriver,
+			Envs:          parent.Config.Env,
+		}
+		defer container.RemoveWorkSpace(nil, name, b.opts.StorageDriver)
+
+		cmd, w := container.NewParentProcess(true, options)
+		if cmd == nil {
+			return nil, fmt.Errorf("new parent process error")
+		}
+		// 构建时不接收标准输入，输出直接打印到构建日志
+		cmd.Stdin = nil
+		cmd.Stdout = b.opts.Output
+		cmd.Stderr = b.opts.Output
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		container.WriteInitConfig(&container.InitConfig{
+			Args:       args,
+			WorkingDir: parent.Config.WorkingDir,
+			User:       parent.Config.User,
+		}, w)
+		if err := cmd.Wait(); err != nil {
+			return nil, fmt.Errorf("the command '%s' returned a non-zero code: %d", createdBy, cmd.ProcessState.ExitCode())
+		}
+
+		return container.CommitLayer(name, b.opts.StorageDriver, parent)
+	})
+}
+
+// commit 以当前镜像为父镜像执行一步构建：change 不为空时修改运行配置，layer 不为 nil 时生成新的层。
+// 缓存的 key 由父镜像 ID、指令以及 extra（例如 COPY 源文件的摘要）组成。
+func (b *Builder) commit(instruction *Instruction, createdBy string, extra []string,
+	layer func(parent *image.Image) (*image.Layer, error)) error {
+	if b.image == nil {
+		return fmt.Errorf("no image specified, FROM must come first")
+	}
+
+	key := image.CacheKey(b.image.ID, instruction.String(), extra...)
+	if !b.opts.NoCache {
+		if img, ok := b.store.CacheLookup(key); ok {
+			fmt.Fprintf(b.opts.Output, " ---> Using cache\n")
+			b.image = img
+			return nil
+		}
+	}
+
+	img := b.image.Child()
+	history := image.History{
+		Created:    img.Created,
+		CreatedBy:  createdBy,
+		EmptyLayer: layer == nil,
+	}
+	if layer == nil {
+		if err := img.Config.ApplyChange(instruction.String()); err != nil {
+			return err
+		}
+	} else {
+		l, err := layer(b.image)
+		if err != nil {
+			return err
+		}
+		img.RootFS.DiffIDs = append(img.RootFS.DiffIDs, l.Digest)
+	}
+	img.History = append(img.History, history)
+
+	id, err := b.store.Create(img)
+	if err != nil {
+		return err
+	}
+	if err := b.store.CacheStore(key, id); err != nil {
+		return err
+	}
+	b.image = img
+
+	return nil
+}
@@ -0,0 +1,331 @@
+package builder
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"godocker/internal/archive"
+	"godocker/internal/image"
+)
+
+// copy 执行 COPY/ADD：将构建上下文中的文件写入一个新的层。
+// ADD 额外支持自动解压本地的 tar 包（包括 gzip 压缩的），不支持 URL。
+func (b *Builder) copy(instruction *Instruction, add bool) error {
+	args, err := copyArgs(instruction.Args)
+	if err != nil {
+		return err
+	}
+	dest := args[len(args)-1]
+
+	var sources []string
+	for _, src := range args[:len(args)-1] {
+		if add && (strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")) {
+			return fmt.Errorf("ADD with URL %s is not supported", src)
+		}
+		matches, err := b.contextPaths(src)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, matches...)
+	}
+
+	// 目标路径以 / 结尾或者有多个源文件时，目标是目录
+	if !path.IsAbs(dest) {
+		dest = path.Join("/", b.image.Config.WorkingDir, dest)
+	}
+	destIsDir := strings.HasSuffix(args[len(args)-1], "/") || len(sources) > 1
+
+	sum, err := contentSum(sources)
+	if err != nil {
+		return err
+	}
+	createdBy := fmt.Sprintf("/bin/sh -c #(nop) %s file:%s in %s", instruction.Cmd, sum, dest)
+
+	return b.commit(instruction, createdBy, []string{sum}, func(parent *image.Image) (*image.Layer, error) {
+		dir, err := ioutil.TempDir(b.store.Root, "build-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+
+		for _, src := range sources {
+			if err := b.copySource(src, dir, dest, destIsDir, add); err != nil {
+				return nil, err
+			}
+		}
+		if err := b.copyParentDirs(parent, dir, dest); err != nil {
+			return nil, err
+		}
+
+		return b.store.CreateLayer(func(w io.Writer) error {
+			return archive.TarLayer(dir, w)
+		})
+	})
+}
+
+// copyArgs 解析 COPY/ADD 的参数，支持 JSON 数组和空格分隔两种形式
+func copyArgs(args string) ([]string, error) {
+	var fields []string
+	if !strings.HasPrefix(args, "[") || json.Unmarshal([]byte(args), &fields) != nil {
+		fields = strings.Fields(args)
+	}
+	for _, field := range fields {
+		if strings.HasPrefix(field, "--") {
+			return nil, fmt.Errorf("flag %s is not supported", field)
+		}
+	}
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("requires at least two arguments")
+	}
+
+	return fields, nil
+}
+
+// contextPaths 将源路径解析为构建上下文中的文件，支持通配符，不允许引用上下文之外的文件。
+// 上级目录中的符号链接在上下文中解析，源本身是符号链接时作为链接复制。
+func (b *Builder) contextPaths(src string) ([]string, error) {
+	contextDir, err := filepath.Abs(b.opts.ContextDir)
+	if err != nil {
+		return nil, err
+	}
+	p := filepath.Join(contextDir, filepath.Clean("/"+src))
+
+	matches, err := filepath.Glob(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: no such file or directory in build context", src)
+	}
+
+	sources := make([]string, 0, len(matches))
+	for _, match := range matches {
+		rel, err := filepath.Rel(contextDir, match)
+		if err != nil {
+			return nil, err
+		}
+		if rel == "." {
+			sources = append(sources, contextDir)
+			continue
+		}
+		dir, err := archive.ResolveInScope(contextDir, filepath.Dir(rel))
+		if err != nil {
+			return nil, err
+		}
+		source := filepath.Join(dir, filepath.Base(rel))
+		if _, err := os.Lstat(source); err != nil {
+			return nil, fmt.Errorf("%s: no such file or directory in build context", src)
+		}
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// copySource 将一个源文件或目录写入 root 中 dest 对应的位置。源是目录时复制目录中的内容。
+func (b *Builder) copySource(src, root, dest string, destIsDir, add bool) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	target := filepath.Join(root, dest)
+	if info.IsDir() || destIsDir {
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return copyTree(src, target)
+	}
+
+	if add && info.Mode().IsRegular() && isArchive(src) {
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return archive.Untar(f, target)
+	}
+
+	if destIsDir {
+		target = filepath.Join(target, filepath.Base(src))
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return copySymlink(src, target)
+	}
+	return copyFile(src, target, info)
+}
+
+// copyParentDirs 新的层中 dest 及其上级目录是父镜像中已有的目录时，沿用原来的权限和属主，
+// 避免复制时创建的目录覆盖例如 /tmp 的 1777
+func (b *Builder) copyParentDirs(parent *image.Image, root, dest string) error {
+	for dir := dest; dir != "/"; dir = path.Dir(dir) {
+		if info, err := os.Lstat(filepath.Join(root, dir)); err != nil || !info.IsDir() {
+			continue
+		}
+		for _, layerDir := range b.store.LayerDirs(parent) {
+			info, err := os.Lstat(filepath.Join(layerDir, dir))
+			if err != nil {
+				continue
+			}
+			if !info.IsDir() {
+				break
+			}
+			st := info.Sys().(*syscall.Stat_t)
+			target := filepath.Join(root, dir)
+			if err := syscall.Chmod(target, st.Mode&07777); err != nil {
+				return err
+			}
+			if err := os.Lchown(target, int(st.Uid), int(st.Gid)); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	return nil
+}
+
+// copyTree 复制目录中的内容，属主统一设置为 root
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			if err := os.MkdirAll(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			return os.Lchown(target, 0, 0)
+		case info.Mode()&os.ModeSymlink != 0:
+			return copySymlink(p, target)
+		case info.Mode().IsRegular():
+			return copyFile(p, target, info)
+		default:
+			return fmt.Errorf("unsupported file type of %s", p)
+		}
+	})
+}
+
+// copySymlink 复制符号链接本身，不跟随链接
+func copySymlink(src, dst string) error {
+	link, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	_ = os.Remove(dst)
+	if err := os.Symlink(link, dst); err != nil {
+		return err
+	}
+
+	return os.Lchown(dst, 0, 0)
+}
+
+func copyFile(src, dst string, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	if err := out.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	return out.Chown(0, 0)
+}
+
+// isArchive 判断文件是否是 tar 包（包括 gzip 压缩的 tar 包）
+func isArchive(p string) bool {
+	f, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	r, err := archive.DecompressStream(f)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+
+	_, err = tar.NewReader(r).Next()
+	return err == nil
+}
+
+// contentSum 计算源文件的摘要作为 COPY/ADD 的缓存依据，包括路径、权限和内容，不包括修改时间
+func contentSum(sources []string) (string, error) {
+	h := sha256.New()
+	for _, src := range sources {
+		err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00%s\x00%o\x00", filepath.Base(src), rel, info.Mode())
+
+			switch {
+			case info.Mode()&os.ModeSymlink != 0:
+				link, err := os.Readlink(p)
+				if err != nil {
+					return err
+				}
+				io.WriteString(h, link)
+			case info.Mode().IsRegular():
+				f, err := os.Open(p)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				if _, err := io.Copy(h, f); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
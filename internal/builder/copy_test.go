@@ -0,0 +1,79 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"godocker/internal/image"
+)
+
+func TestCopySource(t *testing.T) {
+	contextDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(contextDir, "a"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{"link": "a", "out": "/etc"} {
+		if err := os.Symlink(target, filepath.Join(contextDir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := &Builder{opts: Options{ContextDir: contextDir}}
+
+	// 上级目录中的符号链接不能指向上下文之外
+	if sources, err := b.contextPaths("out/passwd"); err == nil {
+		t.Errorf("expected error, got %v", sources)
+	}
+	if sources, err := b.contextPaths("../a"); err != nil || len(sources) != 1 || sources[0] != filepath.Join(contextDir, "a") {
+		t.Errorf("unexpected sources %v, %v", sources, err)
+	}
+
+	// 符号链接作为链接复制，不读取链接指向的文件
+	root := t.TempDir()
+	for _, src := range []string{"link", "out"} {
+		sources, err := b.contextPaths(src)
+		if err != nil || len(sources) != 1 {
+			t.Fatalf("%s: unexpected sources %v, %v", src, sources, err)
+		}
+		if err := b.copySource(sources[0], root, "/dest/", true, false); err != nil {
+			t.Fatalf("copy %s: %v", src, err)
+		}
+		if link, err := os.Readlink(filepath.Join(root, "dest", src)); err != nil || link != map[string]string{"link": "a", "out": "/etc"}[src] {
+			t.Errorf("%s should be copied as a link: %s, %v", src, link, err)
+		}
+	}
+}
+
+func TestCopyParentDirs(t *testing.T) {
+	store := &image.Store{Root: t.TempDir()}
+	parent := &image.Image{RootFS: image.RootFS{DiffIDs: []string{"sha256:0123"}}}
+	tmp := filepath.Join(store.LayerDir("sha256:0123"), "tmp")
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(tmp, 0777|os.ModeSticky); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	for _, dir := range []string{"tmp", "app"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := &Builder{store: store}
+	// COPY dir/ /tmp/ 时目标目录本身也来自父镜像
+	for _, dest := range []string{"/tmp", "/app"} {
+		if err := b.copyParentDirs(parent, root, dest); err != nil {
+			t.Fatalf("%s: %v", dest, err)
+		}
+	}
+
+	// 父镜像中已有的目录保持原来的权限，新建的目录不受影响
+	expected := map[string]os.FileMode{"tmp": os.ModeDir | os.ModeSticky | 0777, "app": os.ModeDir | 0755}
+	for dir, mode := range expected {
+		if info, err := os.Lstat(filepath.Join(root, dir)); err != nil || info.Mode() != mode {
+			t.Errorf("%s: expected %v, got %v, %v", dir, mode, info.Mode(), err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package builder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"godocker/internal/image"
+)
+
+// Instruction Dockerfile 中的一条指令
+type Instruction struct {
+	Line int    // 指令起始行号
+	Cmd  string // 大写的指令名，例如 RUN
+	Args string
+}
+
+func (i *Instruction) String() string {
+	return i.Cmd + " " + i.Args
+}
+
+// supported 支持的指令
+var supported = map[string]bool{
+	"FROM":       true,
+	"RUN":        true,
+	"COPY":       true,
+	"ADD":        true,
+	"ENV":        true,
+	"WORKDIR":    true,
+	"CMD":        true,
+	"ENTRYPOINT": true,
+	"USER":       true,
+	"EXPOSE":     true,
+	"LABEL":      true,
+	"STOPSIGNAL": true,
+}
+
+// Parse 解析 Dockerfile，忽略空行和注释，行尾的 \ 表示指令在下一行继续
+func Parse(r io.Reader) ([]*Instruction, error) {
+	var instructions []*Instruction
+	var current strings.Builder
+	start := 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if current.Len() == 0 {
+			start = line
+		}
+
+		if strings.HasSuffix(text, "\\") {
+			current.WriteString(strings.TrimSpace(strings.TrimSuffix(text, "\\")))
+			current.WriteString(" ")
+			continue
+		}
+		current.WriteString(text)
+
+		instruction, err := parseLine(start, current.String())
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+		current.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current.Len() > 0 {
+		instruction, err := parseLine(start, current.String())
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+	}
+
+	if len(instructions) == 0 {
+		return nil, fmt.Errorf("the Dockerfile cannot be empty")
+	}
+	if instructions[0].Cmd != "FROM" {
+		return nil, fmt.Errorf("line %d: the first instruction must be FROM", instructions[0].Line)
+	}
+	for _, instruction := range instructions[1:] {
+		if instruction.Cmd == "FROM" {
+			return nil, fmt.Errorf("line %d: multi-stage builds are not supported", instruction.Line)
+		}
+	}
+
+	return instructions, nil
+}
+
+func parseLine(line int, text string) (*Instruction, error) {
+	cmd, args, err := image.ParseInstruction(text)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", line, err)
+	}
+	if !supported[cmd] {
+		return nil, fmt.Errorf("line %d: unknown instruction %s", line, cmd)
+	}
+
+	return &Instruction{Line: line, Cmd: cmd, Args: args}, nil
+}
@@ -0,0 +1,40 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	dockerfile := `# syntax comment
+FROM busybox
+
+ENV A=1 \
+    B=2
+run echo hello
+CMD ["sh"]
+`
+	instructions, err := Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"FROM busybox", "ENV A=1 B=2", "RUN echo hello", `CMD ["sh"]`}
+	if len(instructions) != len(expected) {
+		t.Fatalf("expected %d instructions, got %d", len(expected), len(instructions))
+	}
+	for i, instruction := range instructions {
+		if instruction.String() != expected[i] {
+			t.Errorf("instruction %d: expected %q, got %q", i, expected[i], instruction.String())
+		}
+	}
+	if instructions[1].Line != 4 {
+		t.Errorf("expected ENV at line 4, got %d", instructions[1].Line)
+	}
+
+	for _, invalid := range []string{"", "RUN echo", "FROM a\nFROM b", "FROM a\nHEALTHCHECK NONE"} {
+		if _, err := Parse(strings.NewReader(invalid)); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
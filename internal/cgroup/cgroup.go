@@ -1,48 +1,145 @@
 package cgroup
 
 import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
 	"godocker/internal/cgroup/subsystem"
+)
+
+const (
+	// DriverCgroupfs 直接读写 /sys/fs/cgroup
+	DriverCgroupfs = "cgroupfs"
+	// DriverSystemd 由 systemd 创建 transient scope 单元
+	DriverSystemd = "systemd"
 
-	"github.com/sirupsen/logrus"
+	// DefaultParent 默认的上一级 cgroup，systemd 中是 slice
+	DefaultParent        = "godocker"
+	DefaultSystemdParent = "godocker.slice"
 )
 
 type CGroup struct {
 	Path           string
 	ResourceConfig *subsystem.ResourceConfig
+	manager        manager
+}
+
+// manager cgroup 的实现，v1 按 subsystem 分别写入各自的层级，v2 写入统一的层级
+type manager interface {
+	Apply(cGroupPath string, pid int) error
+	Set(cGroupPath string, res *subsystem.ResourceConfig) error
+	Destroy(cGroupPath string) error
+	OOMKillCount(cGroupPath string) (int, error)
+	WatchOOMKill(cGroupPath string) func() bool
+	Freeze(cGroupPath string, frozen bool) error
+	Pids(cGroupPath string) ([]int, error)
+}
+
+var (
+	defaultManager manager
+	detectOnce     sync.Once
+)
+
+// getManager 根据 /sys/fs/cgroup 的文件系统类型选择实现，cgroup2fs 时使用 v2
+func getManager() manager {
+	detectOnce.Do(func() {
+		if IsCgroup2UnifiedMode() {
+			defaultManager = &v2Manager{root: unifiedMountpoint}
+		} else {
+			defaultManager = &v1Manager{}
+		}
+	})
+
+	return defaultManager
 }
 
 func NewCGroup(path string) *CGroup {
 	return &CGroup{
-		Path: path,
+		Path:    path,
+		manager: getManager(),
 	}
 }
 
-func (c *CGroup) Apply(pid int) error {
-	for _, subSys := range subsystem.SubSystems() {
-		if err := subSys.Apply(c.Path, pid); err != nil {
-			logrus.Errorf("CGroup apply %s error %v", subSys.Name(), err)
+// NewCGroupWithDriver 使用指定的 driver 管理 cgroup，driver 为空时使用 cgroupfs
+func NewCGroupWithDriver(driver, path string) *CGroup {
+	if driver == DriverSystemd {
+		return &CGroup{
+			Path:    path,
+			manager: &systemdManager{fs: getManager()},
 		}
 	}
 
-	return nil
+	return NewCGroup(path)
 }
 
-func (c *CGroup) Set(resConfig *subsystem.ResourceConfig) error {
-	for _, subSys := range subsystem.SubSystems() {
-		if err := subSys.Set(c.Path, resConfig); err != nil {
-			logrus.Errorf("CGroup set  %s error %v", subSys.Name(), err)
-		}
+// ValidateDriver 检查 --cgroup-driver 的取值
+func ValidateDriver(driver string) error {
+	if driver != DriverCgroupfs && driver != DriverSystemd {
+		return fmt.Errorf("invalid cgroup driver %q, supported: %s, %s", driver, DriverCgroupfs, DriverSystemd)
 	}
 
 	return nil
 }
 
-func (c *CGroup) Destroy() error {
-	for _, subSys := range subsystem.SubSystems() {
-		if err := subSys.Remove(c.Path); err != nil {
-			logrus.Errorf("CGroup remove  %s error %v", subSys.Name(), err)
+// ContainerPath 容器 cgroup 的路径，cgroupfs 为 <parent>/<id>，
+// systemd 中 parent 是 slice，容器位于其中的 godocker-<id>.scope
+func ContainerPath(driver, parent, id string) (string, error) {
+	if driver != DriverSystemd {
+		if parent == "" {
+			parent = DefaultParent
 		}
+		return path.Join(parent, id), nil
 	}
 
-	return nil
+	if parent == "" {
+		parent = DefaultSystemdParent
+	}
+	if !strings.HasSuffix(parent, ".slice") {
+		return "", fmt.Errorf("cgroup parent %q should be a slice when using the systemd cgroup driver", parent)
+	}
+	slicePath, err := expandSlice(parent)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join("/", slicePath, "godocker-"+id+".scope"), nil
+}
+
+func (c *CGroup) Apply(pid int) error {
+	return c.manager.Apply(c.Path, pid)
+}
+
+func (c *CGroup) Set(resConfig *subsystem.ResourceConfig) error {
+	return c.manager.Set(c.Path, resConfig)
+}
+
+func (c *CGroup) Destroy() error {
+	return c.manager.Destroy(c.Path)
+}
+
+// OOMKillCount cgroup 内的进程被 OOM killer 杀死的次数
+func (c *CGroup) OOMKillCount() (int, error) {
+	return c.manager.OOMKillCount(c.Path)
+}
+
+// WatchOOMKill 开始记录 cgroup 中的 OOM kill，进程退出后调用返回的函数，得到期间是否发生过 OOM kill
+func (c *CGroup) WatchOOMKill() func() bool {
+	return c.manager.WatchOOMKill(c.Path)
+}
+
+// Freeze 冻结 cgroup 中的所有进程
+func (c *CGroup) Freeze() error {
+	return c.manager.Freeze(c.Path, true)
+}
+
+// Thaw 恢复被冻结的进程
+func (c *CGroup) Thaw() error {
+	return c.manager.Freeze(c.Path, false)
+}
+
+// Pids cgroup 中的所有进程
+func (c *CGroup) Pids() ([]int, error) {
+	return c.manager.Pids(c.Path)
 }
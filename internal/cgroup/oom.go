@@ -0,0 +1,148 @@
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	"godocker/internal/cgroup/subsystem"
+
+	"golang.org/x/sys/unix"
+)
+
+// countOOMKill cgroupfs 中的 cgroup 在删除容器时才删除，进程退出后比较前后的 oom_kill 次数即可
+func countOOMKill(m manager, cGroupPath string) func() bool {
+	before, _ := m.OOMKillCount(cGroupPath)
+	return func() bool {
+		after, err := m.OOMKillCount(cGroupPath)
+		return err == nil && after > before
+	}
+}
+
+// oomWatcher 在 cgroup 被删除之前记录其中发生的 OOM kill。
+// v2 使用 inotify 监听 memory.events，v1 通过 cgroup.event_control 把 eventfd 注册到 memory.oom_control
+type oomWatcher struct {
+	count  func() (int, error)
+	before int
+	event  *os.File
+	files  []*os.File // 需要在停止时关闭的 memory.oom_control
+	done   chan struct{}
+
+	mu     sync.Mutex
+	killed bool
+}
+
+func newOOMWatcher(m manager, cGroupPath string) (*oomWatcher, error) {
+	w := &oomWatcher{
+		count: func() (int, error) { return m.OOMKillCount(cGroupPath) },
+		done:  make(chan struct{}),
+	}
+	before, err := w.count()
+	if err != nil {
+		return nil, err
+	}
+	w.before = before
+
+	switch fs := m.(type) {
+	case *v2Manager:
+		err = w.watchEvents(path.Join(fs.path(cGroupPath), "memory.events"))
+	case *v1Manager:
+		err = w.watchOOMControl(cGroupPath)
+	default:
+		err = fmt.Errorf("unsupported cgroup manager %T", m)
+	}
+	if err != nil {
+		w.closeFiles()
+		return nil, err
+	}
+
+	go w.run()
+	return w, nil
+}
+
+func (w *oomWatcher) watchEvents(file string) error {
+	// 非阻塞的 fd 交给 Go 的 poller，Close 时阻塞的 Read 会返回
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("inotify init error %v", err)
+	}
+	w.event = os.NewFile(uintptr(fd), "inotify")
+	if _, err := unix.InotifyAddWatch(fd, file, unix.IN_MODIFY); err != nil {
+		return fmt.Errorf("watch %s error %v", file, err)
+	}
+
+	return nil
+}
+
+func (w *oomWatcher) watchOOMControl(cGroupPath string) error {
+	dir, err := subsystem.MemoryCgroupPath(cGroupPath)
+	if err != nil {
+		return err
+	}
+	fd, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("eventfd error %v", err)
+	}
+	w.event = os.NewFile(uintptr(fd), "eventfd")
+
+	control, err := os.Open(path.Join(dir, "memory.oom_control"))
+	if err != nil {
+		return err
+	}
+	w.files = append(w.files, control)
+	// <event_fd> <fd of memory.oom_control>
+	// 不能调用 File.Fd，它会把 fd 改为阻塞模式，Close 时 Read 不再返回
+	data := fmt.Sprintf("%d %d", fd, control.Fd())
+	if err := os.WriteFile(path.Join(dir, "cgroup.event_control"), []byte(data), 0); err != nil {
+		return fmt.Errorf("register oom event error %v", err)
+	}
+
+	return nil
+}
+
+func (w *oomWatcher) run() {
+	defer close(w.done)
+
+	buf := make([]byte, 4096)
+	for {
+		if _, err := w.event.Read(buf); err != nil {
+			return
+		}
+		// cgroup 被删除时也会收到事件，此时读取失败，只按 oom_kill 次数判断
+		if count, err := w.count(); err == nil && count > w.before {
+			w.mu.Lock()
+			w.killed = true
+			w.mu.Unlock()
+		}
+	}
+}
+
+// Killed 开始监听之后是否发生过 OOM kill
+func (w *oomWatcher) Killed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.killed
+}
+
+// Stop 停止监听，cgroup 仍然存在时再读取一次 oom_kill 次数，返回是否发生过 OOM kill
+func (w *oomWatcher) Stop() bool {
+	w.closeFiles()
+	<-w.done
+
+	if count, err := w.count(); err == nil && count > w.before {
+		return true
+	}
+
+	return w.Killed()
+}
+
+func (w *oomWatcher) closeFiles() {
+	if w.event != nil {
+		_ = w.event.Close()
+	}
+	for _, f := range w.files {
+		_ = f.Close()
+	}
+}
@@ -26,6 +26,18 @@ func (s *CpuSubSys) Set(cGroupPath string, res *ResourceConfig) error {
 			return fmt.Errorf("set cgroup memory fail %v", err)
 		}
 	}
+	if res.Cpus != "" {
+		quota, period, err := ParseCpus(res.Cpus)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(subSysCgroupPath, "cpu.cfs_period_us"), []byte(strconv.FormatInt(period, 10)), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpu period fail %v", err)
+		}
+		if err := ioutil.WriteFile(path.Join(subSysCgroupPath, "cpu.cfs_quota_us"), []byte(strconv.FormatInt(quota, 10)), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpu quota fail %v", err)
+		}
+	}
 
 	return nil
 }
@@ -52,3 +64,21 @@ func (s *CpuSubSys) Remove(cGroupPath string) error {
 
 	return nil
 }
+
+// cpuPeriod CFS 调度周期，单位微秒
+const cpuPeriod = 100000
+
+// ParseCpus 将 --cpus 的 CPU 数量转换为每个调度周期内可以使用的时间，1.5 表示每 100ms 使用 150ms
+func ParseCpus(cpus string) (quota int64, period int64, err error) {
+	value, err := strconv.ParseFloat(cpus, 64)
+	if err != nil || value <= 0 {
+		return 0, 0, fmt.Errorf("invalid cpus: %q", cpus)
+	}
+
+	quota = int64(value * cpuPeriod)
+	if quota < 1000 {
+		return 0, 0, fmt.Errorf("cpus %q is too small", cpus)
+	}
+
+	return quota, cpuPeriod, nil
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 )
 
 type CpuSetSubSys struct {
@@ -20,6 +21,9 @@ func (s *CpuSetSubSys) Set(cGroupPath string, res *ResourceConfig) error {
 	if err != nil {
 		return err
 	}
+	if err := inheritCpuset(subSysCgroupPath, findCGroupMountPoint(s.Name())); err != nil {
+		return err
+	}
 
 	if res.CpuSet != "" {
 		if err := ioutil.WriteFile(path.Join(subSysCgroupPath, "cpuset.cpus"), []byte(res.CpuSet), 0644); err != nil {
@@ -53,3 +57,33 @@ func (s *CpuSetSubSys) Remove(cGroupPath string) error {
 	return nil
 }
 
+// inheritCpuset 新建的 cpuset cgroup 中 cpus 和 mems 为空，这时不能加入进程，从上一级开始逐级继承
+func inheritCpuset(dir string, root string) error {
+	if dir == root || !strings.HasPrefix(dir, root) {
+		return nil
+	}
+	parent := path.Dir(dir)
+	if err := inheritCpuset(parent, root); err != nil {
+		return err
+	}
+
+	for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+		current, err := ioutil.ReadFile(path.Join(dir, file))
+		if err != nil {
+			return fmt.Errorf("read %s error %v", file, err)
+		}
+		if strings.TrimSpace(string(current)) != "" {
+			continue
+		}
+
+		value, err := ioutil.ReadFile(path.Join(parent, file))
+		if err != nil {
+			return fmt.Errorf("read %s error %v", file, err)
+		}
+		if err := ioutil.WriteFile(path.Join(dir, file), value, 0644); err != nil {
+			return fmt.Errorf("set %s fail %v", file, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package subsystem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// freezer.state 的取值
+const (
+	FreezerFrozen = "FROZEN"
+	FreezerThawed = "THAWED"
+)
+
+// FreezerSubSys 冻结 cgroup 中的所有进程，用于 pause/unpause，没有资源限制
+type FreezerSubSys struct {
+}
+
+func (s *FreezerSubSys) Name() string {
+	return "freezer"
+}
+
+func (s *FreezerSubSys) Set(cGroupPath string, res *ResourceConfig) error {
+	_, err := getCGroupPath(s.Name(), cGroupPath, true)
+	return err
+}
+
+func (s *FreezerSubSys) Apply(cGroupPath string, pid int) error {
+	subSysCgroupPath, err := getCGroupPath(s.Name(), cGroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error %v", cGroupPath, err)
+	}
+
+	err = ioutil.WriteFile(path.Join(subSysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644)
+	if err != nil {
+		return fmt.Errorf("set cgroup proc fail %v", err)
+	}
+
+	return nil
+}
+
+func (s *FreezerSubSys) Remove(cGroupPath string) error {
+	subSysCgroupPath, err := getCGroupPath(s.Name(), cGroupPath, false)
+	if err == nil {
+		return os.RemoveAll(subSysCgroupPath)
+	}
+
+	return nil
+}
+
+// SetState 修改 freezer.state 并等待状态生效，state 为 FreezerFrozen 或 FreezerThawed
+func (s *FreezerSubSys) SetState(cGroupPath string, state string) error {
+	subSysCgroupPath, err := getCGroupPath(s.Name(), cGroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error %v", cGroupPath, err)
+	}
+
+	stateFile := path.Join(subSysCgroupPath, "freezer.state")
+	// 冻结时先进入 FREEZING，进程全部冻结后才变为 FROZEN，没有完成时重新写入
+	for i := 0; i < 1000; i++ {
+		if err := ioutil.WriteFile(stateFile, []byte(state), 0644); err != nil {
+			return fmt.Errorf("set freezer state %s fail %v", state, err)
+		}
+		current, err := ioutil.ReadFile(stateFile)
+		if err != nil {
+			return fmt.Errorf("read freezer state fail %v", err)
+		}
+		if strings.TrimSpace(string(current)) == state {
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return fmt.Errorf("set freezer state %s timeout", state)
+}
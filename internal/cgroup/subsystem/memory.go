@@ -6,6 +6,9 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
+
+	"godocker/pkg"
 )
 
 type MemorySubSys struct {
@@ -22,7 +25,12 @@ func (s *MemorySubSys) Set(cGroupPath string, res *ResourceConfig) error {
 	}
 
 	if res.MemoryLimit != "" {
-		if err := ioutil.WriteFile(path.Join(subSysCgroupPath, "memory.limit_in_bytes"), []byte(res.MemoryLimit), 0644); err != nil {
+		limit, err := ParseMemoryLimit(res.MemoryLimit)
+		if err != nil {
+			return err
+		}
+		// memory.limit_in_bytes 写入 -1 表示不限制
+		if err := ioutil.WriteFile(path.Join(subSysCgroupPath, "memory.limit_in_bytes"), []byte(strconv.FormatInt(limit, 10)), 0644); err != nil {
 			return fmt.Errorf("set cgroup memory fail %v", err)
 		}
 	}
@@ -52,3 +60,45 @@ func (s *MemorySubSys) Remove(cGroupPath string) error {
 
 	return nil
 }
+
+// ParseMemoryLimit 解析 --mem 的内存限制，-1 表示不限制，返回 -1
+func ParseMemoryLimit(limit string) (int64, error) {
+	if strings.TrimSpace(limit) == "-1" {
+		return -1, nil
+	}
+
+	value, err := pkg.ParseSize(limit)
+	if err != nil {
+		return 0, fmt.Errorf("parse memory limit error %v", err)
+	}
+
+	return value, nil
+}
+
+// MemoryCgroupPath memory 层级中 cgroup 的目录
+func MemoryCgroupPath(cGroupPath string) (string, error) {
+	return getCGroupPath("memory", cGroupPath, false)
+}
+
+// OOMKillCount 读取 memory.oom_control 中的 oom_kill，cgroup 内的进程被 OOM killer 杀死的次数
+func OOMKillCount(cGroupPath string) (int, error) {
+	subSysCgroupPath, err := getCGroupPath("memory", cGroupPath, false)
+	if err != nil {
+		return 0, err
+	}
+
+	content, err := ioutil.ReadFile(path.Join(subSysCgroupPath, "memory.oom_control"))
+	if err != nil {
+		return 0, err
+	}
+
+	// oom_kill_disable 0\nunder_oom 0\noom_kill 0
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return strconv.Atoi(fields[1])
+		}
+	}
+
+	return 0, fmt.Errorf("oom_kill not found in %s", subSysCgroupPath)
+}
@@ -27,3 +27,18 @@ func TestMemoryCgroup(t *testing.T) {
 		t.Fatalf("cgroup remove %v", err)
 	}
 }
+
+func TestParseMemoryLimit(t *testing.T) {
+	tests := map[string]int64{"100m": 100 << 20, "-1": -1, " -1 ": -1}
+	for limit, expected := range tests {
+		if actual, err := ParseMemoryLimit(limit); err != nil || actual != expected {
+			t.Errorf("parse %q: expected %d, got %d, %v", limit, expected, actual, err)
+		}
+	}
+
+	for _, limit := range []string{"-2", "-1m", "max"} {
+		if _, err := ParseMemoryLimit(limit); err == nil {
+			t.Errorf("expected error for %q", limit)
+		}
+	}
+}
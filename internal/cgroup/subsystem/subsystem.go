@@ -7,6 +7,7 @@ func init() {
 		&MemorySubSys{},
 		&CpuSubSys{},
 		&CpuSetSubSys{},
+		&FreezerSubSys{},
 	}
 }
 
@@ -17,6 +18,7 @@ func SubSystems() []SubSystem {
 type ResourceConfig struct {
 	MemoryLimit string	// 内存限制
 	CpuShare    string  // CPU 时间片权重
+	Cpus        string  // 可以使用的 CPU 数量，例如 1.5
 	CpuSet      string  // CPU 核心数
 }
 
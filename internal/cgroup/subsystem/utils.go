@@ -3,8 +3,10 @@ package subsystem
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 )
 
@@ -40,7 +42,7 @@ func getCGroupPath(subSys string, cGroupPath string, authCreate bool) (string, e
 	_, err := os.Stat(path.Join(cGroupRoot, cGroupPath))
 	if err == nil || (authCreate && os.IsNotExist(err)) { // 如果等
 		if os.IsNotExist(err) {
-			if err := os.Mkdir(path.Join(cGroupRoot, cGroupPath), os.FileMode(0755)); err == nil {
+			if err := os.MkdirAll(path.Join(cGroupRoot, cGroupPath), os.FileMode(0755)); err == nil {
 			} else {
 				return "", fmt.Errorf("error create cgroup %v", err)
 			}
@@ -50,3 +52,27 @@ func getCGroupPath(subSys string, cGroupPath string, authCreate bool) (string, e
 
 	return "", fmt.Errorf("cgroup path error %v", err)
 }
+
+// GetPids 读取 cgroup 中的所有进程，每个 subsystem 中的进程相同，从 memory 中读取
+func GetPids(cGroupPath string) ([]int, error) {
+	subSysCgroupPath, err := getCGroupPath("memory", cGroupPath, false)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(path.Join(subSysCgroupPath, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, field := range strings.Fields(string(content)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid %s in %s", field, subSysCgroupPath)
+		}
+		pids = append(pids, pid)
+	}
+
+	return pids, nil
+}
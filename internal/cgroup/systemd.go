@@ -0,0 +1,287 @@
+package cgroup
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"godocker/internal/cgroup/subsystem"
+
+	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// systemd 操作单元的超时时间
+const systemdTimeout = 30 * time.Second
+
+// systemdConn 用到的 systemd D-Bus 方法，测试中替换为假的实现
+type systemdConn interface {
+	StartTransientUnitContext(ctx context.Context, name string, mode string, properties []systemdDbus.Property, ch chan<- string) (int, error)
+	StopUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	Close()
+}
+
+var newSystemdConn = func() (systemdConn, error) {
+	return systemdDbus.NewSystemConnectionContext(context.Background())
+}
+
+// systemdManager 通过 D-Bus 让 systemd 创建 transient scope 单元并把容器进程放在其中，
+// 资源限制转换为单元的属性。冻结、读取进程等操作直接使用 systemd 创建的 cgroup。
+type systemdManager struct {
+	fs  manager
+	res *subsystem.ResourceConfig // Set 时记录，Apply 创建单元时一起设置
+}
+
+// unitName cgroup 路径的最后一级是 scope 单元名，上一级是所在的 slice，直接位于根下时是 -.slice
+func unitName(cGroupPath string) (string, string) {
+	slice := path.Base(path.Dir(cGroupPath))
+	if slice == "/" || slice == "." {
+		slice = "-.slice"
+	}
+
+	return path.Base(cGroupPath), slice
+}
+
+// v1 cgroup v1 中 systemd 不管理 freezer 和 cpuset，需要直接写 cgroupfs
+func (m *systemdManager) v1() bool {
+	_, ok := m.fs.(*v1Manager)
+	return ok
+}
+
+func (m *systemdManager) Set(cGroupPath string, res *subsystem.ResourceConfig) error {
+	if _, err := resourceProperties(res, !m.v1()); err != nil {
+		return err
+	}
+
+	m.res = res
+	return nil
+}
+
+func (m *systemdManager) Apply(cGroupPath string, pid int) error {
+	unit, slice := unitName(cGroupPath)
+	res := m.res
+	if res == nil {
+		res = &subsystem.ResourceConfig{}
+	}
+
+	properties := []systemdDbus.Property{
+		systemdDbus.PropDescription("godocker container " + unit),
+		systemdDbus.PropSlice(slice),
+		systemdDbus.PropPids(uint32(pid)),
+		{Name: "DefaultDependencies", Value: dbus.MakeVariant(false)},
+		{Name: "Delegate", Value: dbus.MakeVariant(true)},
+	}
+	resources, err := resourceProperties(res, !m.v1())
+	if err != nil {
+		return err
+	}
+	properties = append(properties, resources...)
+
+	if err := runSystemdJob(func(conn systemdConn, ch chan<- string) error {
+		_, err := conn.StartTransientUnitContext(context.Background(), unit, "replace", properties, ch)
+		return err
+	}); err != nil {
+		return fmt.Errorf("start unit %s error %v", unit, err)
+	}
+
+	if m.v1() {
+		for _, subSys := range []subsystem.SubSystem{&subsystem.FreezerSubSys{}, &subsystem.CpuSetSubSys{}} {
+			if err := subSys.Set(cGroupPath, res); err != nil {
+				return err
+			}
+			if err := subSys.Apply(cGroupPath, pid); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *systemdManager) Destroy(cGroupPath string) error {
+	unit, _ := unitName(cGroupPath)
+	err := runSystemdJob(func(conn systemdConn, ch chan<- string) error {
+		_, err := conn.StopUnitContext(context.Background(), unit, "replace", ch)
+		return err
+	})
+	// 单元中的进程全部退出后 systemd 会自动删除 scope
+	if err != nil && !isNoSuchUnit(err) {
+		return fmt.Errorf("stop unit %s error %v", unit, err)
+	}
+
+	// 删除 v1 中直接创建的 freezer、cpuset cgroup
+	return m.fs.Destroy(cGroupPath)
+}
+
+func (m *systemdManager) OOMKillCount(cGroupPath string) (int, error) {
+	return m.fs.OOMKillCount(cGroupPath)
+}
+
+// WatchOOMKill systemd 在 scope 中的进程全部退出后立即删除 cgroup，进程退出后无法再读取 oom_kill，
+// 需要在容器运行期间监听
+func (m *systemdManager) WatchOOMKill(cGroupPath string) func() bool {
+	w, err := newOOMWatcher(m.fs, cGroupPath)
+	if err != nil {
+		logrus.Warnf("Watch oom kill of %s error %v", cGroupPath, err)
+		return countOOMKill(m.fs, cGroupPath)
+	}
+
+	return w.Stop
+}
+
+func (m *systemdManager) Freeze(cGroupPath string, frozen bool) error {
+	return m.fs.Freeze(cGroupPath, frozen)
+}
+
+func (m *systemdManager) Pids(cGroupPath string) ([]int, error) {
+	return m.fs.Pids(cGroupPath)
+}
+
+// runSystemdJob 调用 systemd 的方法并等待返回的 job 完成
+func runSystemdJob(call func(conn systemdConn, ch chan<- string) error) error {
+	conn, err := newSystemdConn()
+	if err != nil {
+		return fmt.Errorf("connect systemd error %v", err)
+	}
+	defer conn.Close()
+
+	ch := make(chan string, 1)
+	if err := call(conn, ch); err != nil {
+		return err
+	}
+
+	select {
+	case result := <-ch:
+		if result != "done" {
+			return fmt.Errorf("job result %s", result)
+		}
+		return nil
+	case <-time.After(systemdTimeout):
+		return fmt.Errorf("job timeout")
+	}
+}
+
+// isNoSuchUnit godbus 返回的错误可能是 dbus.Error 或 *dbus.Error
+func isNoSuchUnit(err error) bool {
+	switch e := err.(type) {
+	case dbus.Error:
+		return e.Name == "org.freedesktop.systemd1.NoSuchUnit"
+	case *dbus.Error:
+		return e.Name == "org.freedesktop.systemd1.NoSuchUnit"
+	}
+
+	return false
+}
+
+// resourceProperties 将资源限制转换为单元属性，v2 使用 MemoryMax、CPUWeight、AllowedCPUs
+func resourceProperties(res *subsystem.ResourceConfig, v2 bool) ([]systemdDbus.Property, error) {
+	var properties []systemdDbus.Property
+	if res == nil {
+		return properties, nil
+	}
+
+	if res.MemoryLimit != "" {
+		limit, err := subsystem.ParseMemoryLimit(res.MemoryLimit)
+		if err != nil {
+			return nil, err
+		}
+		name := "MemoryLimit"
+		if v2 {
+			name = "MemoryMax"
+		}
+		// systemd 使用 uint64 的最大值表示 infinity
+		value := uint64(math.MaxUint64)
+		if limit >= 0 {
+			value = uint64(limit)
+		}
+		properties = append(properties, systemdDbus.Property{Name: name, Value: dbus.MakeVariant(value)})
+	}
+	if res.CpuShare != "" {
+		shares, err := strconv.ParseUint(res.CpuShare, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse cpu shares error %v", err)
+		}
+		if v2 {
+			properties = append(properties, systemdDbus.Property{Name: "CPUWeight", Value: dbus.MakeVariant(sharesToWeight(shares))})
+		} else {
+			properties = append(properties, systemdDbus.Property{Name: "CPUShares", Value: dbus.MakeVariant(shares)})
+		}
+	}
+	if res.Cpus != "" {
+		quota, period, err := subsystem.ParseCpus(res.Cpus)
+		if err != nil {
+			return nil, err
+		}
+		// 每秒可以使用的 CPU 时间，单位微秒
+		perSec := uint64(quota) * uint64(time.Second/time.Microsecond) / uint64(period)
+		properties = append(properties, systemdDbus.Property{Name: "CPUQuotaPerSecUSec", Value: dbus.MakeVariant(perSec)})
+	}
+	// v1 中 systemd 不管理 cpuset，由 Apply 直接写入 cgroupfs
+	if res.CpuSet != "" && v2 {
+		mask, err := cpusetToBitmask(res.CpuSet)
+		if err != nil {
+			return nil, err
+		}
+		properties = append(properties, systemdDbus.Property{Name: "AllowedCPUs", Value: dbus.MakeVariant(mask)})
+	}
+
+	return properties, nil
+}
+
+// cpusetToBitmask 将 0-2,4 这样的 CPU 列表转换为 AllowedCPUs 使用的位图，第 n 个 CPU 对应第 n/8 个字节的第 n%8 位
+func cpusetToBitmask(cpuset string) ([]byte, error) {
+	var mask []byte
+	for _, part := range strings.Split(cpuset, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		if !isRange {
+			last = first
+		}
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpuset %q", cpuset)
+		}
+		end, err := strconv.Atoi(last)
+		if err != nil || start < 0 || end < start {
+			return nil, fmt.Errorf("invalid cpuset %q", cpuset)
+		}
+
+		for cpu := start; cpu <= end; cpu++ {
+			for len(mask) <= cpu/8 {
+				mask = append(mask, 0)
+			}
+			mask[cpu/8] |= 1 << (cpu % 8)
+		}
+	}
+
+	return mask, nil
+}
+
+// expandSlice 将 slice 单元名转换为 cgroup 路径，a-b.slice 位于 a.slice/a-b.slice
+func expandSlice(slice string) (string, error) {
+	name := strings.TrimSuffix(slice, ".slice")
+	if name == slice || strings.Contains(name, "/") {
+		return "", fmt.Errorf("invalid slice name %q", slice)
+	}
+	if name == "-" {
+		return "", nil
+	}
+
+	var (
+		expanded string
+		prefix   string
+	)
+	for _, component := range strings.Split(name, "-") {
+		if component == "" {
+			return "", fmt.Errorf("invalid slice name %q", slice)
+		}
+		expanded = path.Join(expanded, prefix+component+".slice")
+		prefix += component + "-"
+	}
+
+	return expanded, nil
+}
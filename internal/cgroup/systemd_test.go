@@ -0,0 +1,204 @@
+package cgroup
+
+import (
+	"context"
+	"math"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"godocker/internal/cgroup/subsystem"
+
+	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+)
+
+// fakeSystemd 假的 systemd D-Bus 服务，记录创建的单元和属性
+type fakeSystemd struct {
+	units map[string][]systemdDbus.Property
+}
+
+func (f *fakeSystemd) StartTransientUnitContext(ctx context.Context, name string, mode string, properties []systemdDbus.Property, ch chan<- string) (int, error) {
+	f.units[name] = properties
+	ch <- "done"
+	return 1, nil
+}
+
+func (f *fakeSystemd) StopUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	if _, ok := f.units[name]; !ok {
+		return 0, &dbus.Error{Name: "org.freedesktop.systemd1.NoSuchUnit"}
+	}
+	delete(f.units, name)
+	ch <- "done"
+	return 2, nil
+}
+
+func (f *fakeSystemd) Close() {}
+
+func TestSystemdManager(t *testing.T) {
+	fake := &fakeSystemd{units: make(map[string][]systemdDbus.Property)}
+	newConn := newSystemdConn
+	newSystemdConn = func() (systemdConn, error) { return fake, nil }
+	defer func() { newSystemdConn = newConn }()
+
+	cGroupPath, err := ContainerPath(DriverSystemd, "", "1234567890")
+	if err != nil || cGroupPath != "/godocker.slice/godocker-1234567890.scope" {
+		t.Fatalf("unexpected path %q, %v", cGroupPath, err)
+	}
+
+	m := &systemdManager{fs: &v2Manager{root: t.TempDir()}}
+	if err := m.Set(cGroupPath, &subsystem.ResourceConfig{MemoryLimit: "100m", Cpus: "0.5"}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := m.Apply(cGroupPath, 1234); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	properties, ok := fake.units["godocker-1234567890.scope"]
+	if !ok {
+		t.Fatalf("unit not created: %v", fake.units)
+	}
+	expected := map[string]interface{}{
+		"Slice":              "godocker.slice",
+		"PIDs":               []uint32{1234},
+		"Delegate":           true,
+		"MemoryMax":          uint64(104857600),
+		"CPUQuotaPerSecUSec": uint64(500000),
+	}
+	values := make(map[string]interface{})
+	for _, property := range properties {
+		values[property.Name] = property.Value.Value()
+	}
+	for name, value := range expected {
+		if !reflect.DeepEqual(values[name], value) {
+			t.Errorf("%s: expected %v, got %v", name, value, values[name])
+		}
+	}
+
+	if err := m.Destroy(cGroupPath); err != nil {
+		t.Fatalf("destroy: %v", err)
+	}
+	if len(fake.units) != 0 {
+		t.Errorf("unit not stopped: %v", fake.units)
+	}
+	// 进程退出后 systemd 已经删除了 scope
+	if err := m.Destroy(cGroupPath); err != nil {
+		t.Errorf("destroy removed unit: %v", err)
+	}
+}
+
+func TestResourceProperties(t *testing.T) {
+	res := &subsystem.ResourceConfig{MemoryLimit: "1g", CpuShare: "512", CpuSet: "0-1"}
+	for _, v2 := range []bool{false, true} {
+		properties, err := resourceProperties(res, v2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, property := range properties {
+			names = append(names, property.Name)
+		}
+		expected := []string{"MemoryLimit", "CPUShares"}
+		if v2 {
+			expected = []string{"MemoryMax", "CPUWeight", "AllowedCPUs"}
+		}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("v2 %v: expected %v, got %v", v2, expected, names)
+		}
+	}
+
+	properties, err := resourceProperties(&subsystem.ResourceConfig{MemoryLimit: "-1"}, true)
+	if err != nil || len(properties) != 1 || properties[0].Value.Value() != uint64(math.MaxUint64) {
+		t.Errorf("unexpected unlimited memory %v, %v", properties, err)
+	}
+	if _, err := resourceProperties(&subsystem.ResourceConfig{CpuShare: "abc"}, true); err == nil {
+		t.Errorf("expected error for invalid cpu shares")
+	}
+}
+
+func TestCpusetToBitmask(t *testing.T) {
+	mask, err := cpusetToBitmask("0-2,9")
+	if err != nil || !reflect.DeepEqual(mask, []byte{0x07, 0x02}) {
+		t.Errorf("unexpected mask %v, %v", mask, err)
+	}
+
+	for _, cpuset := range []string{"", "a", "3-1", "-1"} {
+		if _, err := cpusetToBitmask(cpuset); err == nil {
+			t.Errorf("expected error for %q", cpuset)
+		}
+	}
+}
+
+func TestContainerPath(t *testing.T) {
+	tests := []struct {
+		driver, parent, expected string
+	}{
+		{DriverCgroupfs, "", "godocker/id"},
+		{DriverCgroupfs, "custom", "custom/id"},
+		{DriverSystemd, "-.slice", "/godocker-id.scope"},
+		{DriverSystemd, "a-b.slice", "/a.slice/a-b.slice/godocker-id.scope"},
+	}
+	for _, test := range tests {
+		if p, err := ContainerPath(test.driver, test.parent, "id"); err != nil || p != test.expected {
+			t.Errorf("%s %q: expected %q, got %q, %v", test.driver, test.parent, test.expected, p, err)
+		}
+	}
+
+	for _, parent := range []string{"godocker", "a--b.slice", "a/b.slice"} {
+		if _, err := ContainerPath(DriverSystemd, parent, "id"); err == nil {
+			t.Errorf("expected error for %q", parent)
+		}
+	}
+
+	if unit, slice := unitName("/godocker-id.scope"); unit != "godocker-id.scope" || slice != "-.slice" {
+		t.Errorf("unexpected unit %s in slice %s", unit, slice)
+	}
+}
+
+func TestWatchOOMKill(t *testing.T) {
+	root := t.TempDir()
+	cGroupPath := "/godocker.slice/godocker-1234567890.scope"
+	dir := path.Join(root, cGroupPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	events := path.Join(dir, "memory.events")
+	if err := os.WriteFile(events, []byte("oom 0\noom_kill 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &systemdManager{fs: &v2Manager{root: root}}
+	w, err := newOOMWatcher(m.fs, cGroupPath)
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	if err := os.WriteFile(events, []byte("oom 1\noom_kill 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && !w.Killed(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 进程退出后 systemd 删除了 scope，已经记录的 OOM kill 不会丢失
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Stop() {
+		t.Errorf("expected oom kill after scope removed")
+	}
+
+	// 没有发生 OOM kill
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(events, []byte("oom 0\noom_kill 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stop := m.WatchOOMKill(cGroupPath)
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if stop() {
+		t.Errorf("unexpected oom kill")
+	}
+}
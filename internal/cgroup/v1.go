@@ -0,0 +1,62 @@
+package cgroup
+
+import (
+	"godocker/internal/cgroup/subsystem"
+
+	"github.com/sirupsen/logrus"
+)
+
+// v1Manager cgroup v1，每个 subsystem 挂载在自己的层级中
+type v1Manager struct {
+}
+
+func (m *v1Manager) Apply(cGroupPath string, pid int) error {
+	for _, subSys := range subsystem.SubSystems() {
+		if err := subSys.Apply(cGroupPath, pid); err != nil {
+			logrus.Errorf("CGroup apply %s error %v", subSys.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+func (m *v1Manager) Set(cGroupPath string, resConfig *subsystem.ResourceConfig) error {
+	for _, subSys := range subsystem.SubSystems() {
+		if err := subSys.Set(cGroupPath, resConfig); err != nil {
+			logrus.Errorf("CGroup set  %s error %v", subSys.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+func (m *v1Manager) Destroy(cGroupPath string) error {
+	for _, subSys := range subsystem.SubSystems() {
+		if err := subSys.Remove(cGroupPath); err != nil {
+			logrus.Errorf("CGroup remove  %s error %v", subSys.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+func (m *v1Manager) OOMKillCount(cGroupPath string) (int, error) {
+	return subsystem.OOMKillCount(cGroupPath)
+}
+
+func (m *v1Manager) WatchOOMKill(cGroupPath string) func() bool {
+	return countOOMKill(m, cGroupPath)
+}
+
+func (m *v1Manager) Freeze(cGroupPath string, frozen bool) error {
+	state := subsystem.FreezerThawed
+	if frozen {
+		state = subsystem.FreezerFrozen
+	}
+
+	return (&subsystem.FreezerSubSys{}).SetState(cGroupPath, state)
+}
+
+func (m *v1Manager) Pids(cGroupPath string) ([]int, error) {
+	return subsystem.GetPids(cGroupPath)
+}
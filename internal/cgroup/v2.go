@@ -0,0 +1,223 @@
+package cgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"godocker/internal/cgroup/subsystem"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	unifiedMountpoint = "/sys/fs/cgroup"
+	cgroup2SuperMagic = 0x63677270
+)
+
+// v2Controllers 容器 cgroup 需要的控制器，freezer 在 v2 中是每个 cgroup 自带的 cgroup.freeze
+var v2Controllers = []string{"cpu", "cpuset", "memory", "pids"}
+
+// IsCgroup2UnifiedMode /sys/fs/cgroup 挂载的是 cgroup2fs，只使用 cgroup v2
+func IsCgroup2UnifiedMode() bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(unifiedMountpoint, &st); err != nil {
+		return false
+	}
+
+	return st.Type == cgroup2SuperMagic
+}
+
+// v2Manager cgroup v2，所有控制器在同一个层级中，
+// 上一级的 cgroup.subtree_control 启用控制器后，下一级才有对应的文件
+type v2Manager struct {
+	root string
+}
+
+func (m *v2Manager) path(cGroupPath string) string {
+	return path.Join(m.root, cGroupPath)
+}
+
+// create 创建 cgroup，并从根开始逐级启用上一级可用的控制器
+func (m *v2Manager) create(cGroupPath string) error {
+	if err := os.MkdirAll(m.path(cGroupPath), 0755); err != nil {
+		return fmt.Errorf("error create cgroup %v", err)
+	}
+
+	dir := m.root
+	for _, name := range strings.Split(strings.Trim(path.Clean(cGroupPath), "/"), "/") {
+		content, err := ioutil.ReadFile(path.Join(dir, "cgroup.controllers"))
+		if err != nil {
+			return fmt.Errorf("read cgroup controllers error %v", err)
+		}
+		available := strings.Fields(string(content))
+		for _, controller := range v2Controllers {
+			if !contains(available, controller) {
+				continue
+			}
+			if err := ioutil.WriteFile(path.Join(dir, "cgroup.subtree_control"), []byte("+"+controller), 0644); err != nil {
+				logrus.Warnf("Enable controller %s in %s error %v", controller, dir, err)
+			}
+		}
+		dir = path.Join(dir, name)
+	}
+
+	return nil
+}
+
+func (m *v2Manager) Apply(cGroupPath string, pid int) error {
+	if err := m.create(cGroupPath); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path.Join(m.path(cGroupPath), "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("set cgroup proc fail %v", err)
+	}
+
+	return nil
+}
+
+func (m *v2Manager) Set(cGroupPath string, res *subsystem.ResourceConfig) error {
+	if err := m.create(cGroupPath); err != nil {
+		return err
+	}
+
+	files := make(map[string]string)
+	if res.MemoryLimit != "" {
+		limit, err := subsystem.ParseMemoryLimit(res.MemoryLimit)
+		if err != nil {
+			return err
+		}
+		files["memory.max"] = strconv.FormatInt(limit, 10)
+		if limit < 0 {
+			files["memory.max"] = "max"
+		}
+	}
+	if res.CpuShare != "" {
+		shares, err := strconv.ParseUint(res.CpuShare, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse cpu shares error %v", err)
+		}
+		files["cpu.weight"] = strconv.FormatUint(sharesToWeight(shares), 10)
+	}
+	if res.Cpus != "" {
+		quota, period, err := subsystem.ParseCpus(res.Cpus)
+		if err != nil {
+			return err
+		}
+		files["cpu.max"] = fmt.Sprintf("%d %d", quota, period)
+	}
+	if res.CpuSet != "" {
+		files["cpuset.cpus"] = res.CpuSet
+	}
+
+	for file, value := range files {
+		if err := ioutil.WriteFile(path.Join(m.path(cGroupPath), file), []byte(value), 0644); err != nil {
+			return fmt.Errorf("set cgroup %s fail %v", file, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *v2Manager) Destroy(cGroupPath string) error {
+	// cgroup 目录中只有内核的控制文件，直接 rmdir
+	if err := os.Remove(m.path(cGroupPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (m *v2Manager) OOMKillCount(cGroupPath string) (int, error) {
+	content, err := ioutil.ReadFile(path.Join(m.path(cGroupPath), "memory.events"))
+	if err != nil {
+		return 0, err
+	}
+
+	// low 0\nhigh 0\nmax 0\noom 0\noom_kill 0
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return strconv.Atoi(fields[1])
+		}
+	}
+
+	return 0, fmt.Errorf("oom_kill not found in %s", m.path(cGroupPath))
+}
+
+func (m *v2Manager) WatchOOMKill(cGroupPath string) func() bool {
+	return countOOMKill(m, cGroupPath)
+}
+
+func (m *v2Manager) Freeze(cGroupPath string, frozen bool) error {
+	state := "0"
+	if frozen {
+		state = "1"
+	}
+
+	dir := m.path(cGroupPath)
+	if err := ioutil.WriteFile(path.Join(dir, "cgroup.freeze"), []byte(state), 0644); err != nil {
+		return fmt.Errorf("set cgroup freeze %s fail %v", state, err)
+	}
+
+	// 所有进程冻结后 cgroup.events 中的 frozen 才会变化
+	for i := 0; i < 1000; i++ {
+		content, err := ioutil.ReadFile(path.Join(dir, "cgroup.events"))
+		if err != nil {
+			return fmt.Errorf("read cgroup events fail %v", err)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if line == "frozen "+state {
+				return nil
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return fmt.Errorf("set cgroup freeze %s timeout", state)
+}
+
+func (m *v2Manager) Pids(cGroupPath string) ([]int, error) {
+	content, err := ioutil.ReadFile(path.Join(m.path(cGroupPath), "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, field := range strings.Fields(string(content)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid %s in %s", field, m.path(cGroupPath))
+		}
+		pids = append(pids, pid)
+	}
+
+	return pids, nil
+}
+
+// sharesToWeight 将 v1 的 cpu.shares（2-262144，默认 1024）换算为 v2 的 cpu.weight（1-10000，默认 100）
+func sharesToWeight(shares uint64) uint64 {
+	if shares < 2 {
+		shares = 2
+	} else if shares > 262144 {
+		shares = 262144
+	}
+
+	return 1 + ((shares-2)*9999)/262142
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,88 @@
+package cgroup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"godocker/internal/cgroup/subsystem"
+)
+
+func TestV2Manager(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{root, path.Join(root, "godocker")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(dir, "cgroup.controllers"), []byte("cpuset cpu io memory pids\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := &v2Manager{root: root}
+	res := &subsystem.ResourceConfig{MemoryLimit: "100m", CpuShare: "1024", Cpus: "1.5", CpuSet: "0-1"}
+	if err := m.Set("godocker/test", res); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	expected := map[string]string{
+		"memory.max":  "104857600",
+		"cpu.weight":  "39",
+		"cpu.max":     "150000 100000",
+		"cpuset.cpus": "0-1",
+	}
+	for file, value := range expected {
+		content, err := ioutil.ReadFile(path.Join(root, "godocker/test", file))
+		if err != nil || string(content) != value {
+			t.Errorf("%s: expected %q, got %q, %v", file, value, content, err)
+		}
+	}
+	// 临时目录中每次写入覆盖上一次，只能看到最后启用的控制器
+	if content, _ := ioutil.ReadFile(path.Join(root, "godocker", "cgroup.subtree_control")); string(content) != "+pids" {
+		t.Errorf("unexpected subtree_control %q", content)
+	}
+
+	if err := m.Apply("godocker/test", 1234); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if pids, err := m.Pids("godocker/test"); err != nil || !reflect.DeepEqual(pids, []int{1234}) {
+		t.Errorf("unexpected pids %v, %v", pids, err)
+	}
+
+	events := "low 0\nhigh 0\nmax 2\noom 1\noom_kill 1\n"
+	if err := ioutil.WriteFile(path.Join(root, "godocker/test", "memory.events"), []byte(events), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := m.OOMKillCount("godocker/test"); err != nil || count != 1 {
+		t.Errorf("unexpected oom kill count %d, %v", count, err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(root, "godocker/test", "cgroup.events"), []byte("populated 1\nfrozen 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Freeze("godocker/test", true); err != nil {
+		t.Errorf("freeze: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(path.Join(root, "godocker/test", "cgroup.freeze")); strings.TrimSpace(string(content)) != "1" {
+		t.Errorf("unexpected cgroup.freeze %q", content)
+	}
+
+	// -1 表示不限制
+	if err := m.Set("godocker/test", &subsystem.ResourceConfig{MemoryLimit: "-1"}); err != nil {
+		t.Fatalf("set unlimited: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(path.Join(root, "godocker/test", "memory.max")); string(content) != "max" {
+		t.Errorf("unexpected memory.max %q", content)
+	}
+}
+
+func TestSharesToWeight(t *testing.T) {
+	tests := map[uint64]uint64{0: 1, 2: 1, 1024: 39, 262144: 10000, 1000000: 10000}
+	for shares, expected := range tests {
+		if weight := sharesToWeight(shares); weight != expected {
+			t.Errorf("shares %d: expected %d, got %d", shares, expected, weight)
+		}
+	}
+}
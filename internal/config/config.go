@@ -0,0 +1,79 @@
+package config
+
+import (
+	"os"
+	"path"
+)
+
+const (
+	DefaultRoot     = "/var/lib/godocker"
+	DefaultExecRoot = "/var/run/godocker"
+
+	DefaultCgroupDriver = "cgroupfs"
+
+	EnvRoot         = "GODOCKER_ROOT"
+	EnvExecRoot     = "GODOCKER_EXEC_ROOT"
+	EnvCgroupDriver = "GODOCKER_CGROUP_DRIVER"
+)
+
+// Config godocker 实例的全局配置，同一台主机上使用不同 Root/ExecRoot 的实例互相隔离
+type Config struct {
+	Root         string // 持久化数据目录：镜像、容器读写层
+	ExecRoot     string // 运行时状态目录：容器信息、日志、网络、IPAM
+	CgroupDriver string // 新建容器使用的 cgroup driver：cgroupfs 或 systemd
+}
+
+var current = New()
+
+// New 返回默认配置，GODOCKER_ROOT/GODOCKER_EXEC_ROOT/GODOCKER_CGROUP_DRIVER 环境变量优先
+func New() *Config {
+	c := &Config{
+		Root:         DefaultRoot,
+		ExecRoot:     DefaultExecRoot,
+		CgroupDriver: DefaultCgroupDriver,
+	}
+	if root := os.Getenv(EnvRoot); root != "" {
+		c.Root = root
+	}
+	if execRoot := os.Getenv(EnvExecRoot); execRoot != "" {
+		c.ExecRoot = execRoot
+	}
+	if driver := os.Getenv(EnvCgroupDriver); driver != "" {
+		c.CgroupDriver = driver
+	}
+
+	return c
+}
+
+// Get 返回当前生效的配置
+func Get() *Config {
+	return current
+}
+
+// Set 替换当前配置，并导出到环境变量，使 init/exec/shim 等子进程使用相同的配置
+func Set(c *Config) {
+	current = c
+	_ = os.Setenv(EnvRoot, c.Root)
+	_ = os.Setenv(EnvExecRoot, c.ExecRoot)
+	_ = os.Setenv(EnvCgroupDriver, c.CgroupDriver)
+}
+
+// ContainersPath 所有容器运行时信息的目录
+func (c *Config) ContainersPath() string {
+	return path.Join(c.ExecRoot, "containers")
+}
+
+// ContainerPath 容器运行时信息目录，存放 config.json 和日志
+func (c *Config) ContainerPath(name string) string {
+	return path.Join(c.ContainersPath(), name)
+}
+
+// NetworkPath 网络配置目录
+func (c *Config) NetworkPath() string {
+	return path.Join(c.ExecRoot, "network", "network")
+}
+
+// IPAMPath IPAM 网段分配信息文件
+func (c *Config) IPAMPath() string {
+	return path.Join(c.ExecRoot, "network", "ipam", "subnet.json")
+}
@@ -13,21 +13,40 @@ var (
 	oldRootPath = ".pivot_root"
 )
 
-func setUpMount() {
+// setUpMount 在容器的 mount namespace 中切换 rootfs 并挂载 proc、dev、卷和 tmpfs。
+// --read-only 的 rootfs 在创建工作目录之后再由 remountReadOnly 重新挂载为只读
+func setUpMount(mounts []Mount) error {
 	mountPrivate()
 
 	pwd, err := os.Getwd() // 获取当前工作目录 pwd = print work dir ？
 	if err != nil {
 		logrus.Errorf("Get current dir error: %v", err)
-		return
+		return err
+	}
+
+	// bind mount 需要访问宿主机的路径，所以在 pivot_root 之前挂载到新的 rootfs 中
+	if err := mountBinds(mounts, pwd); err != nil {
+		return err
 	}
 
 	if err := pivotRoot(pwd); err != nil {
 		logrus.Error(err)
-		return
+		return err
 	}
 
 	mountProc()
+
+	return mountTmpfs(mounts)
+}
+
+// remountReadOnly 只修改 rootfs 自身的挂载标志，/proc、/dev、卷和 tmpfs 是独立的挂载点，仍然可写
+func remountReadOnly(root string) error {
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	if err := syscall.Mount("", root, "", flags, ""); err != nil {
+		return fmt.Errorf("remount rootfs read-only error: %v", err)
+	}
+
+	return nil
 }
 
 func mountPrivate() {
@@ -1,17 +1,74 @@
 package container
 
 import (
-	"fmt"
-	"os/exec"
-	"path"
+	"io"
 
-	"github.com/sirupsen/logrus"
+	"godocker/internal/image"
 )
 
-func CommitContainer(containerName, imageName string) {
-	source := fmt.Sprintf(mntPath, containerName) // fmt.Sprintf("%s/%s.tar", rootPath, imageName)
-	target := path.Join(rootPath, imageName) + ".tar"
-	if _, err := exec.Command("tar", "-czf", target, "-C", source, ".").CombinedOutput(); err != nil {
-		logrus.Errorf("Tar folder %s error %v", target, err)
+// CommitOptions commit 时对新镜像的补充说明
+type CommitOptions struct {
+	Author  string
+	Message string
+	Changes []string // Dockerfile 风格的指令，例如 ENV key=value
+}
+
+// CommitContainer 将容器读写层的变化保存为新的镜像层，新镜像以容器的镜像为父镜像，返回新镜像 ID
+func CommitContainer(containerName, ref string, opts CommitOptions) (string, error) {
+	containerInfo, err := GetContainerInfo(containerName)
+	if err != nil {
+		return "", err
+	}
+
+	store := image.NewStore()
+	parent, err := store.Get(containerInfo.ImageID)
+	if err != nil {
+		return "", err
+	}
+
+	img := parent.Child()
+	img.Author = opts.Author
+	img.Comment = opts.Message
+	for _, change := range opts.Changes {
+		if err := img.Config.ApplyChange(change); err != nil {
+			return "", err
+		}
+	}
+
+	layer, err := CommitLayer(containerInfo.Name, containerInfo.Storage, parent)
+	if err != nil {
+		return "", err
 	}
+	img.RootFS.DiffIDs = append(img.RootFS.DiffIDs, layer.Digest)
+	img.History = append(img.History, image.History{
+		Created:   img.Created,
+		CreatedBy: containerInfo.Command,
+		Author:    opts.Author,
+		Comment:   opts.Message,
+	})
+
+	id, err := store.Create(img)
+	if err != nil {
+		return "", err
+	}
+	if ref != "" {
+		if err := store.Tag(id, ref); err != nil {
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+// CommitLayer 将容器读写层相对于 parent 镜像的变化写入镜像存储，返回新的镜像层
+func CommitLayer(containerName, driverName string, parent *image.Image) (*image.Layer, error) {
+	driver, err := storageDriver(driverName)
+	if err != nil {
+		return nil, err
+	}
+
+	store := image.NewStore()
+	return store.CreateLayer(func(w io.Writer) error {
+		return driver.Diff(containerName, store.LayerDirs(parent), w)
+	})
 }
@@ -1,16 +1,20 @@
 package container
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"godocker/internal/cgroup"
+	"godocker/pkg"
+
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,31 +22,88 @@ type Status string
 
 const (
 	Running Status = "running"
+	Paused  Status = "paused"
 	Stop    Status = "stop"
 	Exit    Status = "exit"
 )
 
+// InfoVersion config.json 的格式版本，读取旧版本时自动迁移，见 migrateInfo
+const InfoVersion = 1
+
 type Info struct {
-	ID          string    `json:"id"`
-	Pid         string    `json:"pid"`
-	Name        string    `json:"name"`
-	Command     string    `json:"command"`
-	Status      Status    `json:"status"`
-	Volume      string    `json:"volume"`
-	PortMapping []string  `json:"port_mapping"`
-	CreatedAt   time.Time `json:"created_at"`
+	Version   int        `json:"version"`
+	ID        string     `json:"id"`
+	Pid       string     `json:"pid"`
+	Name      string     `json:"name"`
+	Command   string     `json:"command"`
+	Args      []string   `json:"args"`
+	Status    Status     `json:"status"`
+	Image     string     `json:"image"`
+	ImageID   string     `json:"image_id"`
+	Storage   string     `json:"storage_driver"`
+	Networks  []Endpoint `json:"networks,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	// CgroupPath 容器自己的 cgroup，见 cgroup.ContainerPath，CgroupDriver 为创建容器时使用的 driver
+	CgroupPath   string `json:"cgroup_path,omitempty"`
+	CgroupDriver string `json:"cgroup_driver,omitempty"`
+	// 容器进程退出后由 shim 记录，见 RecordExit
+	ExitCode   int       `json:"exit_code"`
+	OOMKilled  bool      `json:"oom_killed"`
+	FinishedAt time.Time `json:"finished_at"`
+	// RestartCount 按重启策略重新启动的次数，手动 start 时清零
+	RestartCount int `json:"restart_count"`
+	// ManuallyStopped 被 stop/kill 停止，shim 不再按重启策略重新启动
+	ManuallyStopped bool `json:"manually_stopped,omitempty"`
+	// Config 创建容器时的完整参数
+	Config *Options `json:"config"`
+}
+
+// CGroup 容器的 cgroup，使用创建容器时的 driver 管理
+func (info *Info) CGroup() *cgroup.CGroup {
+	return cgroup.NewCGroupWithDriver(info.CgroupDriver, info.CgroupPath)
+}
+
+// Options 根据保存的配置还原创建容器时的参数，用于 start 重新启动容器
+func (info *Info) Options() Options {
+	var options Options
+	if info.Config != nil {
+		options = *info.Config
+	}
+	options.Name = info.Name
+	options.StorageDriver = info.Storage
+	// 重新启动的容器总是在后台运行
+	options.TTY = false
+	options.Detach = true
+
+	return options
+}
+
+// Endpoint 容器接入网络时分配的资源，删除容器时据此释放
+type Endpoint struct {
+	Network     string   `json:"network"`
+	Device      string   `json:"device"` // 宿主机一侧的 veth
+	IPAddress   string   `json:"ip_address"`
+	PortMapping []string `json:"port_mapping,omitempty"` // 已经添加的 DNAT 规则
 }
 
 var (
 	selfProcessExe    = "/proc/self/exe"
-	rootPath          = "/home/kexin/projects/godocker/"
-	mntPath           = "/home/kexin/projects/godocker/mnt/%s"
-	wirtePath         = "/home/kexin/projects/godocker/write/%s"
-	RuntimePath       = "/var/run/godocker/%s"
 	RuntimeConfigFile = "config.json"
 	RuntimeLogFile    = "container.log"
+	RuntimeLockFile   = "config.lock"
 )
 
+// runtimeDir 容器运行时信息目录
+func runtimeDir(name string) string {
+	return path.Join(containersDir(), name)
+}
+
+// ContainerExists 名字是否已经被容器使用
+func ContainerExists(name string) bool {
+	_, err := os.Stat(runtimeDir(name))
+	return err == nil
+}
+
 // 1. /proc/self/exe 调用中，/proc/self/ 指的是当前运行进程自己的环境，exec 其实就是调用了自己，使用这种方式对自己进行初始化。
 // 2. args 是参数，其中 init 是传递给本进程的第一个参数。
 // 3. clone 参数就是 namespace 隔离标识。
@@ -64,14 +125,15 @@ func NewParentProcess(tty bool, options Options) (*exec.Cmd, *os.File) {
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 	} else {
-		dir := fmt.Sprintf(RuntimePath, options.Name)
+		dir := runtimeDir(options.Name)
 		if err := os.MkdirAll(dir, 0622); err != nil {
 			logrus.Errorf("NewParentProcess mkdir %s error %v", dir, err)
 			return nil, nil
 		}
 
+		// 重新启动容器时日志追加在原来的日志之后
 		stdLogFile := path.Join(dir, RuntimeLogFile)
-		file, err := os.Create(stdLogFile)
+		file, err := os.OpenFile(stdLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
 			logrus.Errorf("NewParentProcess create file %s error %v", stdLogFile, err)
 			return nil, nil
@@ -81,23 +143,63 @@ func NewParentProcess(tty bool, options Options) (*exec.Cmd, *os.File) {
 		cmd.Stderr = file
 	}
 
-	// volume imageName containerName
-	NewWorkSpace(options.Volume, options.Image, options.Name)
+	// mounts imageID containerName storageDriver
+	rootfs, err := NewWorkSpace(options.Mounts, options.ImageID, options.Name, options.StorageDriver, options.StorageOpt)
+	if err != nil {
+		logrus.Errorf("NewParentProcess new workspace error %v", err)
+		return nil, nil
+	}
 
 	cmd.ExtraFiles = []*os.File{r}
-	cmd.Dir = fmt.Sprintf(mntPath, options.Name)    // 进程启动时的目录.
+	cmd.Dir = rootfs                                // 进程启动时的目录.
 	cmd.Env = append(os.Environ(), options.Envs...) // env
 
 	return cmd, w
 }
 
 func RunInitProcess() error {
-	cmdArray := readInitCommand()
+	initConfig, err := readInitConfig()
+	if err != nil {
+		return err
+	}
+	cmdArray := initConfig.Args
 	if len(cmdArray) == 0 {
 		return fmt.Errorf("run container get user command error, cmdArray is nil")
 	}
 
-	setUpMount()
+	if err := setUpMount(initConfig.Mounts); err != nil {
+		return err
+	}
+
+	// 容器在独立的 UTS namespace 中，修改主机名不影响宿主机
+	if initConfig.Hostname != "" {
+		if err := syscall.Sethostname([]byte(initConfig.Hostname)); err != nil {
+			return fmt.Errorf("set hostname %s error: %v", initConfig.Hostname, err)
+		}
+	}
+
+	// 镜像中不存在的工作目录需要在 rootfs 变为只读之前创建，只读只影响 rootfs 自身，卷和 tmpfs 仍然可写
+	if initConfig.WorkingDir != "" {
+		if err := os.MkdirAll(initConfig.WorkingDir, 0755); err != nil {
+			return fmt.Errorf("mkdir working dir %s error: %v", initConfig.WorkingDir, err)
+		}
+	}
+	if initConfig.ReadOnly {
+		if err := remountReadOnly("/"); err != nil {
+			return err
+		}
+	}
+	if initConfig.WorkingDir != "" {
+		if err := syscall.Chdir(initConfig.WorkingDir); err != nil {
+			return fmt.Errorf("chdir %s error: %v", initConfig.WorkingDir, err)
+		}
+	}
+
+	if initConfig.User != "" {
+		if err := setUser(initConfig.User); err != nil {
+			return err
+		}
+	}
 
 	path, err := exec.LookPath(cmdArray[0])
 	if err != nil {
@@ -113,63 +215,263 @@ func RunInitProcess() error {
 	return nil
 }
 
-func StopContainer(name string) {
-	pid, err := getContainerPidByName(name)
+// StopContainer 发送镜像的 StopSignal（默认 SIGTERM），timeout 内没有退出时杀死容器内的所有进程，
+// 进程退出后才把容器标记为停止
+func StopContainer(name string, timeout time.Duration) error {
+	containerInfo, err := GetContainerInfo(name)
 	if err != nil {
-		logrus.Errorf("Get container pid by name %s error %v", name, err)
-		return
+		return fmt.Errorf("no such container: %s", name)
+	}
+	if err := markManuallyStopped(containerInfo); err != nil {
+		return err
 	}
 
-	pidInt, err := strconv.Atoi(pid)
+	if containerInfo.Running() {
+		pid, err := strconv.Atoi(containerInfo.Pid)
+		if err != nil {
+			return err
+		}
+		thawIfPaused(containerInfo)
+
+		sig := syscall.SIGTERM
+		if stopSignal := containerInfo.Options().StopSignal; stopSignal != "" {
+			if sig, err = pkg.ParseSignal(stopSignal); err != nil {
+				logrus.Warnf("Container %s stop signal %s error %v, use SIGTERM", name, stopSignal, err)
+				sig = syscall.SIGTERM
+			}
+		}
+		if err := syscall.Kill(pid, sig); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("stop container %s error: %v", name, err)
+		}
+
+		if !waitProcessExit(pid, timeout) {
+			if err := killProcess(pid, containerInfo); err != nil {
+				return fmt.Errorf("stop container %s error: %v", name, err)
+			}
+		}
+	}
+
+	return markStopped(name)
+}
+
+// KillContainer 发送 SIGKILL 并等待容器进程退出，用于 kill 和 rm -f
+func KillContainer(name string) error {
+	containerInfo, err := GetContainerInfo(name)
 	if err != nil {
-		logrus.Errorf("Conver pid from striing to int error %v", err)
-		return
+		return err
+	}
+	if err := markManuallyStopped(containerInfo); err != nil {
+		return err
 	}
 
-	if err := syscall.Kill(pidInt, syscall.SIGTERM); err != nil {
-		logrus.Errorf("Stop container %s error %v", name, err)
-		return
+	if containerInfo.Running() {
+		pid, err := strconv.Atoi(containerInfo.Pid)
+		if err != nil {
+			return err
+		}
+		thawIfPaused(containerInfo)
+		if err := killProcess(pid, containerInfo); err != nil {
+			return fmt.Errorf("kill container %s error: %v", name, err)
+		}
 	}
 
-	containerInfo, err := getContainerInfo(name)
+	return markStopped(name)
+}
+
+// SignalContainer 向运行中的容器 init 进程发送信号，容器退出后由 shim 记录状态。SIGKILL 等待容器退出并标记为停止。
+func SignalContainer(name string, sig syscall.Signal) error {
+	containerInfo, err := GetContainerInfo(name)
 	if err != nil {
-		logrus.Errorf("Get container %s info %v", name, err)
-		return
+		return fmt.Errorf("no such container: %s", name)
+	}
+	if !containerInfo.Running() {
+		return fmt.Errorf("container %s is not running", name)
+	}
+	if sig == syscall.SIGKILL {
+		return KillContainer(name)
 	}
+	if containerInfo.Status == Paused {
+		return fmt.Errorf("container %s is paused, unpause the container before sending signal", name)
+	}
+
+	pid, err := strconv.Atoi(containerInfo.Pid)
+	if err != nil {
+		return err
+	}
+
+	return syscall.Kill(pid, sig)
+}
 
-	containerInfo.Pid = ""
-	containerInfo.Status = Stop
+// killProcess 杀死容器 cgroup 中的所有进程并等待 init 进程退出。
+// 没有 cgroup 的旧容器只杀死 init 进程，init 进程退出时内核会杀死 PID namespace 中的所有进程。
+func killProcess(pid int, containerInfo *Info) error {
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	if containerInfo.CgroupPath != "" {
+		pids, err := containerInfo.CGroup().Pids()
+		if err != nil {
+			logrus.Warnf("Get pids of cgroup %s error %v", containerInfo.CgroupPath, err)
+		}
+		for _, p := range pids {
+			_ = syscall.Kill(p, syscall.SIGKILL)
+		}
+	}
+
+	// 容器进程退出后 mount namespace 才会释放，之后才能卸载读写层
+	if !waitProcessExit(pid, 10*time.Second) {
+		return fmt.Errorf("process %d did not exit after SIGKILL", pid)
+	}
+
+	return nil
+}
+
+// waitProcessExit 等待进程退出，超时返回 false
+func waitProcessExit(pid int, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); processExists(pid); time.Sleep(100 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// markManuallyStopped 在发送信号之前记录容器是被手动停止的，shim 看到容器退出时据此不再重启容器
+func markManuallyStopped(containerInfo *Info) error {
+	if containerInfo.ManuallyStopped {
+		return nil
+	}
+
+	containerInfo.ManuallyStopped = true
+	_, err := ModifyContainerInfo(containerInfo.Name, func(info *Info) error {
+		info.ManuallyStopped = true
+		return nil
+	})
+	return err
+}
+
+// markStopped 进程退出后基于最新的容器信息标记为停止，不覆盖 shim 在此期间记录的退出码
+func markStopped(name string) error {
+	_, err := ModifyContainerInfo(name, func(containerInfo *Info) error {
+		containerInfo.Pid = ""
+		containerInfo.Status = Stop
+		return nil
+	})
+	return err
+}
 
-	content, err := json.Marshal(containerInfo)
+// RecordExit 记录容器进程的退出码和退出时间，由等待容器进程的 shim 在进程退出后调用。
+// 容器已经被删除或者已经重新启动时不做修改。
+func RecordExit(name string, pid int, exitCode int, oomKilled bool) error {
+	_, err := ModifyContainerInfo(name, func(containerInfo *Info) error {
+		if containerInfo.Pid != "" && containerInfo.Pid != strconv.Itoa(pid) {
+			return errRestarted
+		}
+
+		// stop/kill 停止的容器保持 stop 状态，自己退出的容器标记为 exit
+		if containerInfo.Status == Running || containerInfo.Status == Paused {
+			containerInfo.Status = Exit
+		}
+		containerInfo.Pid = ""
+		containerInfo.ExitCode = exitCode
+		containerInfo.OOMKilled = oomKilled
+		containerInfo.FinishedAt = time.Now()
+		return nil
+	})
+	if err == errRestarted {
+		return nil
+	}
+
+	return err
+}
+
+// errRestarted 容器已经使用新的进程重新启动
+var errRestarted = errors.New("container has been restarted")
+
+// WaitContainer 阻塞直到容器退出，返回 shim 记录的退出码
+func WaitContainer(name string) (int, error) {
+	var (
+		running    bool
+		finishedAt time.Time
+	)
+	for retry := 0; ; time.Sleep(100 * time.Millisecond) {
+		containerInfo, err := GetContainerInfo(name)
+		if err != nil {
+			return -1, fmt.Errorf("no such container: %s", name)
+		}
+		if containerInfo.Running() {
+			running = true
+			finishedAt = containerInfo.FinishedAt
+			continue
+		}
+
+		// stop 会先修改状态，等待期间退出的容器需要等 shim 写入新的退出时间
+		if containerInfo.Status != Running && containerInfo.Status != Paused && (!running || !containerInfo.FinishedAt.Equal(finishedAt)) {
+			return containerInfo.ExitCode, nil
+		}
+		// 没有 shim 的容器退出后不会记录退出码
+		if retry++; retry == 50 {
+			return -1, fmt.Errorf("container %s exited but its exit code was not recorded", name)
+		}
+	}
+}
+
+// Running 记录的状态为运行中或者暂停并且进程仍然存在。没有 shim 的容器自己退出时状态不会更新，需要检查进程。
+func (info *Info) Running() bool {
+	if info.Status != Running && info.Status != Paused {
+		return false
+	}
+	pid, err := strconv.Atoi(info.Pid)
+	if err != nil {
+		return false
+	}
+
+	return processExists(pid)
+}
+
+// processExists 进程存在并且不是僵尸进程
+func processExists(pid int) bool {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
 	if err != nil {
-		logrus.Errorf("Json marshal %s error %v", name, err)
-		return
+		return false
 	}
 
-	configPath := path.Join(fmt.Sprintf(RuntimePath, name), RuntimeConfigFile)
-	if err := ioutil.WriteFile(configPath, content, 0622); err != nil {
-		logrus.Errorf("Write file %s error %v", configPath, err)
+	// 1234 (sh) S 1 ...，进程名可能包含空格和括号，状态在最后一个 ) 之后
+	stat := string(content)
+	i := strings.LastIndex(stat, ")")
+	if i < 0 || i+2 >= len(stat) {
+		return false
 	}
+
+	return stat[i+2] != 'Z'
 }
 
-func RemoveContainer(name string) {
-	// remove container
-	containerInfo, err := getContainerInfo(name)
+// RemoveContainer 删除已经停止的容器：卸载挂载、释放命名卷、删除读写层以及运行时目录。
+// 网络资源由调用方在此之前释放，见 network.DisconnectNetwork。
+// 每一步在资源已经不存在时都直接跳过，删除中途失败后可以再次执行。
+func RemoveContainer(name string) error {
+	containerInfo, err := GetContainerInfo(name)
 	if err != nil {
-		logrus.Errorf("Get container %s info error %v", name, err)
-		return
+		return fmt.Errorf("no such container: %s", name)
 	}
 
-	if containerInfo.Status != Stop {
-		logrus.Errorf("Couldn't remove running container.")
-		return
+	if containerInfo.Running() {
+		return fmt.Errorf("you cannot remove a running container %s, stop the container before attempting removal or force remove", name)
 	}
 
-	dir := fmt.Sprintf(RuntimePath, name)
+	// mounts containerName storageDriver
+	RemoveWorkSpace(containerInfo.Options().Mounts, containerInfo.Name, containerInfo.Storage)
+	if containerInfo.CgroupPath != "" {
+		if err := containerInfo.CGroup().Destroy(); err != nil {
+			logrus.Warnf("Remove container %s cgroup error %v", name, err)
+		}
+	}
+
+	dir := runtimeDir(name)
 	if err := os.RemoveAll(dir); err != nil {
-		logrus.Errorf("Remove file %s error %v", dir, err)
-		return
+		return fmt.Errorf("remove dir %s error: %v", dir, err)
 	}
 
-	// todo: remove mount path and write path.
+	return nil
 }
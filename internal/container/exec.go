@@ -23,7 +23,7 @@ func Exec(containerName string, comArray []string) {
 	}
 
 	cmdStr := strings.Join(comArray, " ")
-	logrus.Infof("PID %d, Command %s", pid, cmdStr)
+	logrus.Infof("PID %s, Command %s", pid, cmdStr)
 
 	cmd := exec.Command("/proc/self/exe", "exec")
 	cmd.Stdin = os.Stdin
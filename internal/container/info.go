@@ -8,44 +8,46 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
+	"godocker/internal/cgroup"
+	"godocker/internal/config"
+	"godocker/internal/storage"
 	"godocker/pkg"
 
 	"github.com/sirupsen/logrus"
 )
 
-func ListContainer() {
-	dir := fmt.Sprintf(RuntimePath, "")
-	dir = dir[:len(dir)-1]
-	files, err := ioutil.ReadDir(dir)
+// ListContainer 列出所有容器，size 为 true 时额外展示读写层的大小以及配额
+func ListContainer(size bool) {
+	containers, err := GetContainerInfos()
 	if err != nil {
-		logrus.Errorf("Read dir %s error %v", dir, err)
+		logrus.Errorf("Get container infos error %v", err)
 		return
 	}
 
-	var containers []*Info
-	for _, file := range files {
-		tmpContainerInfo, err := getContainerInfo(file.Name()) //
-		if err != nil {
-			logrus.Errorf("Get container info error: %v", err)
-			continue
-		}
-		containers = append(containers, tmpContainerInfo)
-	}
-
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	fmt.Fprintf(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
+	fmt.Fprintf(w, "ID\tNAME\tIMAGE\tPID\tSTATUS\tCOMMAND\tCREATED")
+	if size {
+		fmt.Fprintf(w, "\tSIZE")
+	}
+	fmt.Fprintf(w, "\n")
 	for _, item := range containers {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s",
 			item.ID,
 			item.Name,
+			item.Image,
 			item.Pid,
 			item.Status,
 			item.Command,
 			item.CreatedAt.String(),
 		)
+		if size {
+			fmt.Fprintf(w, "\t%s", formatUsage(item.usage()))
+		}
+		fmt.Fprintf(w, "\n")
 	}
 
 	if err := w.Flush(); err != nil {
@@ -54,26 +56,141 @@ func ListContainer() {
 	}
 }
 
-func getContainerInfo(name string) (*Info, error) {
-	configFileDir := fmt.Sprintf(RuntimePath, name)
-	configFile := path.Join(configFileDir, RuntimeConfigFile)
+// InspectContainer 以 JSON 格式输出容器的信息，包括读写层的大小 SizeRw 和配额 SizeLimit
+func InspectContainer(names []string) error {
+	type containerInspect struct {
+		*Info
+		SizeRw    int64 `json:"SizeRw"`
+		SizeLimit int64 `json:"SizeLimit,omitempty"`
+	}
+
+	var result []containerInspect
+	for _, name := range names {
+		info, err := GetContainerInfo(name)
+		if err != nil {
+			return fmt.Errorf("no such container: %s", name)
+		}
+		item := containerInspect{Info: info}
+		if usage := info.usage(); usage != nil {
+			item.SizeRw = usage.Used
+			item.SizeLimit = usage.Limit
+		}
+		result = append(result, item)
+	}
+
+	content, err := json.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(content))
+
+	return nil
+}
+
+// usage 读取容器读写层的磁盘占用，失败时返回 nil
+func (info *Info) usage() *storage.DiskUsage {
+	driver, err := storageDriver(info.Storage)
+	if err != nil {
+		logrus.Errorf("Get storage driver error: %v", err)
+		return nil
+	}
+	usage, err := driver.Usage(info.Name)
+	if err != nil {
+		logrus.Errorf("Get disk usage of %s error: %v", info.Name, err)
+		return nil
+	}
+
+	return usage
+}
+
+// formatUsage 12345 (limit 2147483648)
+func formatUsage(usage *storage.DiskUsage) string {
+	if usage == nil {
+		return "-"
+	}
+	if usage.Limit == 0 {
+		return strconv.FormatInt(usage.Used, 10)
+	}
+
+	return fmt.Sprintf("%d (limit %d)", usage.Used, usage.Limit)
+}
+
+// GetContainerInfos 读取所有容器的信息
+func GetContainerInfos() ([]*Info, error) {
+	dir := containersDir()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var containers []*Info
+	for _, file := range files {
+		tmpContainerInfo, err := GetContainerInfo(file.Name())
+		if err != nil {
+			logrus.Errorf("Get container info error: %v", err)
+			continue
+		}
+		containers = append(containers, tmpContainerInfo)
+	}
+
+	return containers, nil
+}
+
+// ImageInUse 判断是否有容器使用该镜像
+func ImageInUse(imageID string) bool {
+	containers, err := GetContainerInfos()
+	if err != nil {
+		logrus.Errorf("Get container infos error %v", err)
+		return true
+	}
+
+	for _, item := range containers {
+		if item.ImageID == imageID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetContainerInfo 读取容器的信息
+func GetContainerInfo(name string) (*Info, error) {
+	info, migrated, err := loadContainerInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	// 旧版本的配置迁移后写回，之后按当前版本读取
+	if migrated {
+		if err := UpdateContainerInfo(info); err != nil {
+			logrus.Errorf("Update migrated container %s info error: %v", name, err)
+		}
+	}
+
+	return info, nil
+}
+
+func loadContainerInfo(name string) (*Info, bool, error) {
+	configFile := path.Join(runtimeDir(name), RuntimeConfigFile)
 	content, err := ioutil.ReadFile(configFile)
 	if err != nil {
 		logrus.Errorf("Read file %s erorr %v", configFile, err)
-		return nil, err
+		return nil, false, err
 	}
 
-	var tmpContainer Info
-	if err := json.Unmarshal(content, &tmpContainer); err != nil {
+	info, migrated, err := migrateInfo(content)
+	if err != nil {
 		logrus.Errorf("Json unmarshal error: %v", err)
-		return nil, err
+		return nil, false, err
 	}
 
-	return &tmpContainer, nil
+	return info, migrated, nil
 }
 
 func getContainerPidByName(name string) (string, error) {
-	info, err := getContainerInfo(name)
+	info, err := GetContainerInfo(name)
 	if err != nil {
 		return "", err
 	}
@@ -81,54 +198,125 @@ func getContainerPidByName(name string) (string, error) {
 	return info.Pid, nil
 }
 
-func RecordContainerInfo(pid int, name string, commands []string, volume string) (string, error) {
+func RecordContainerInfo(pid int, commands []string, options Options) (*Info, error) {
 	id := pkg.RandStringBytes(10)
-	command := strings.Join(commands, "")
+	name := options.Name
+	storageDriver := options.StorageDriver
+	if storageDriver == "" {
+		storageDriver = storage.DefaultName()
+	}
+	command := strings.Join(commands, " ")
 	if name == "" {
 		name = id
 	}
+	options.Name = name
+	cgroupDriver := config.Get().CgroupDriver
+	cgroupPath, err := cgroup.ContainerPath(cgroupDriver, options.CgroupParent, id)
+	if err != nil {
+		return nil, err
+	}
 
 	info := &Info{
-		ID:        id,
-		Pid:       strconv.Itoa(pid),
-		Name:      name,
-		CreatedAt: time.Now(),
-		Command:   command,
-		Status:    Running,
-		Volume:    volume,
+		Version:      InfoVersion,
+		ID:           id,
+		Pid:          strconv.Itoa(pid),
+		Name:         name,
+		CreatedAt:    time.Now(),
+		Command:      command,
+		Args:         commands,
+		Status:       Running,
+		Image:        options.Image,
+		ImageID:      options.ImageID,
+		Storage:      storageDriver,
+		CgroupPath:   cgroupPath,
+		CgroupDriver: cgroupDriver,
+		Config:       &options,
 	}
-	buf, err := json.Marshal(info)
-	if err != nil {
+	dir := runtimeDir(name)
+	if err := os.MkdirAll(dir, 0622); err != nil {
+		logrus.Errorf("Mkdir  error %s error %v", dir, err)
+		return nil, err
+	}
+
+	if err := writeContainerInfo(info); err != nil {
 		logrus.Errorf("Recrod container error: %v", err)
-		return "", err
+		return nil, err
 	}
 
-	dir := fmt.Sprintf(RuntimePath, name)
-	if err := os.MkdirAll(dir, 0622); err != nil {
-		logrus.Errorf("Mkdir  error %s error %v", dir, err)
-		return "", err
+	return info, nil
+}
+
+// UpdateContainerInfo 将修改后的容器信息整体写回 config.json。
+// 需要基于最新内容修改时使用 ModifyContainerInfo，避免覆盖其他进程同时做的修改
+func UpdateContainerInfo(info *Info) error {
+	unlock, err := lockContainerInfo(info.Name)
+	if err != nil {
+		return err
 	}
+	defer unlock()
+
+	return writeContainerInfo(info)
+}
 
-	fileName := path.Join(dir, RuntimeConfigFile)
-	file, err := os.Create(fileName)
+// ModifyContainerInfo 持有容器的文件锁读取最新的容器信息，modify 修改后写回。
+// shim、stop/kill、wait 等多个进程会同时修改同一个容器，读改写必须在锁内完成，modify 返回错误时不写回
+func ModifyContainerInfo(name string, modify func(info *Info) error) (*Info, error) {
+	unlock, err := lockContainerInfo(name)
 	if err != nil {
-		logrus.Errorf("Create file %s error %v", fileName, err)
-		return "", err
+		return nil, err
 	}
-	defer func() {
-		_ = file.Close()
-	}()
+	defer unlock()
 
-	if _, err := file.WriteString(string(buf)); err != nil {
-		logrus.Errorf("File write content error: %v", err)
-		return "", err
+	info, _, err := loadContainerInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := modify(info); err != nil {
+		return info, err
+	}
+
+	return info, writeContainerInfo(info)
+}
+
+// writeContainerInfo 写入临时文件后 rename，读取的进程不会看到写了一半的 config.json
+func writeContainerInfo(info *Info) error {
+	content, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("json marshal %s error: %v", info.Name, err)
+	}
+
+	configPath := path.Join(runtimeDir(info.Name), RuntimeConfigFile)
+	tmp := configPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0622); err != nil {
+		return fmt.Errorf("write file %s error: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, configPath); err != nil {
+		return fmt.Errorf("rename %s error: %v", tmp, err)
+	}
+
+	return nil
+}
+
+// lockContainerInfo 对容器目录中的锁文件加 flock，容器已经被删除时返回错误
+func lockContainerInfo(name string) (func(), error) {
+	lockPath := path.Join(runtimeDir(name), RuntimeLockFile)
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("no such container: %s", name)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
 	}
 
-	return name, nil
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
 }
 
 func RemoveContainerInfo(name string) {
-	dir := fmt.Sprintf(RuntimePath, name)
+	dir := runtimeDir(name)
 	if err := os.RemoveAll(dir); err != nil {
 		logrus.Errorf("Remove dir %s error %v", dir, err)
 	}
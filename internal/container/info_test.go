@@ -0,0 +1,50 @@
+package container
+
+import (
+	"sync"
+	"testing"
+
+	"godocker/internal/config"
+)
+
+func TestModifyContainerInfo(t *testing.T) {
+	previous := config.Get()
+	config.Set(&config.Config{Root: t.TempDir(), ExecRoot: t.TempDir(), CgroupDriver: config.DefaultCgroupDriver})
+	defer config.Set(previous)
+
+	info, err := RecordContainerInfo(1, []string{"top"}, Options{Name: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 并发的读改写不会丢失修改，读取时也不会看到写了一半的文件
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := ModifyContainerInfo(info.Name, func(info *Info) error {
+				info.RestartCount++
+				return nil
+			}); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := GetContainerInfo(info.Name); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	current, err := GetContainerInfo(info.Name)
+	if err != nil || current.RestartCount != 20 {
+		t.Errorf("unexpected restart count %+v, %v", current, err)
+	}
+
+	if _, err := ModifyContainerInfo("missing", func(info *Info) error { return nil }); err == nil {
+		t.Error("expected error for missing container")
+	}
+}
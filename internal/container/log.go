@@ -10,7 +10,7 @@ import (
 )
 
 func LogContainer(name string) {
-	logFilePath := path.Join(fmt.Sprintf(RuntimePath, name), RuntimeLogFile)
+	logFilePath := path.Join(runtimeDir(name), RuntimeLogFile)
 	file, err := os.Open(logFilePath)
 	if err != nil {
 		logrus.Errorf("Log container open file %s error %v", logFilePath, err)
@@ -0,0 +1,129 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"godocker/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// infoV0 没有 version 字段的 config.json，即最初版本记录的容器信息
+type infoV0 struct {
+	ID          string    `json:"id"`
+	Pid         string    `json:"pid"`
+	Name        string    `json:"name"`
+	Command     string    `json:"command"`
+	Status      Status    `json:"status"`
+	Volume      string    `json:"volume"`
+	PortMapping []string  `json:"port_mapping"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// migrateInfo 解析 config.json，旧版本的格式转换为当前版本，migrated 表示发生了转换
+func migrateInfo(content []byte) (info *Info, migrated bool, err error) {
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(content, &header); err != nil {
+		return nil, false, err
+	}
+
+	switch header.Version {
+	case InfoVersion:
+		info = &Info{}
+		if err := json.Unmarshal(content, info); err != nil {
+			return nil, false, err
+		}
+		return info, false, nil
+	case 0:
+		var old infoV0
+		if err := json.Unmarshal(content, &old); err != nil {
+			return nil, false, err
+		}
+		return old.migrate(), true, nil
+	default:
+		return nil, false, fmt.Errorf("unsupported container config version %d", header.Version)
+	}
+}
+
+// migrate 最初版本没有记录镜像，命令是直接拼接起来的参数，无法还原，迁移后的容器不能重新启动
+func (old *infoV0) migrate() *Info {
+	var mounts []Mount
+	// volume 为 -v 的原始参数 host:ctr
+	if old.Volume != "" {
+		m, err := ParseVolume(old.Volume)
+		if err != nil {
+			logrus.Errorf("Migrate volume %s of container %s error: %v", old.Volume, old.Name, err)
+		} else {
+			mounts = append(mounts, m)
+		}
+	}
+
+	return &Info{
+		Version:   InfoVersion,
+		ID:        old.ID,
+		Pid:       old.Pid,
+		Name:      old.Name,
+		Command:   old.Command,
+		Status:    old.Status,
+		CreatedAt: old.CreatedAt,
+		Config: &Options{
+			Name:        old.Name,
+			Detach:      true,
+			Mounts:      mounts,
+			PortMapping: old.PortMapping,
+		},
+	}
+}
+
+var migrateLayoutOnce sync.Once
+
+// containersDir 所有容器运行时信息的目录，第一次使用时迁移旧版本的目录结构
+func containersDir() string {
+	c := config.Get()
+	migrateLayoutOnce.Do(func() {
+		migrateLayout(c.ExecRoot, c.ContainersPath())
+	})
+
+	return c.ContainersPath()
+}
+
+// migrateLayout 旧版本的容器目录直接位于 exec-root 下（/var/run/godocker/<name>），
+// 包含 config.json 的目录移动到 containers 中，同名的容器已经存在时保留旧目录
+func migrateLayout(execRoot, containersPath string) {
+	files, err := ioutil.ReadDir(execRoot)
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		legacy := path.Join(execRoot, file.Name())
+		if !file.IsDir() || legacy == containersPath || file.Name() == "network" {
+			continue
+		}
+		if _, err := os.Stat(path.Join(legacy, RuntimeConfigFile)); err != nil {
+			continue
+		}
+
+		target := path.Join(containersPath, file.Name())
+		if _, err := os.Stat(target); err == nil {
+			logrus.Warnf("Container %s already exists in %s, keep %s", file.Name(), containersPath, legacy)
+			continue
+		}
+		if err := os.MkdirAll(containersPath, 0622); err != nil {
+			logrus.Errorf("Mkdir %s error %v", containersPath, err)
+			return
+		}
+		// 其他进程可能已经迁移了这个容器
+		if err := os.Rename(legacy, target); err != nil && !os.IsNotExist(err) {
+			logrus.Errorf("Move container %s to %s error %v", legacy, target, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package container
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestMigrateInfo(t *testing.T) {
+	// 最初版本记录的 config.json，command 是直接拼接起来的参数
+	info, migrated, err := migrateInfo([]byte(`{"id":"4938573012","pid":"1234","name":"web","command":"sh-ctop -b",` +
+		`"status":"running","volume":"/data:/data","port_mapping":null,"created_at":"2022-06-01T08:00:00.123456789+08:00"}`))
+	if err != nil || !migrated {
+		t.Fatalf("migrate: %v, %v", migrated, err)
+	}
+	if info.Version != InfoVersion || info.Name != "web" || info.Pid != "1234" || info.Status != Running ||
+		info.Command != "sh-ctop -b" || info.CreatedAt.Nanosecond() != 123456789 {
+		t.Errorf("unexpected info %+v", info)
+	}
+	// 无法还原参数和镜像，不能重新启动
+	if len(info.Args) != 0 || info.ImageID != "" {
+		t.Errorf("unexpected args %v, image %s", info.Args, info.ImageID)
+	}
+	options := info.Options()
+	expected := []Mount{{Type: MountTypeBind, Source: "/data", Destination: "/data"}}
+	if !reflect.DeepEqual(options.Mounts, expected) || len(options.PortMapping) != 0 {
+		t.Errorf("unexpected options %+v", options)
+	}
+
+	info, migrated, err = migrateInfo([]byte(`{"id":"4938573012","pid":"","name":"4938573012","command":"sh-ctop -b",` +
+		`"status":"stop","volume":"","port_mapping":null,"created_at":"2022-06-01T08:00:00.123456789+08:00"}`))
+	if err != nil || !migrated || info.Status != Stop || len(info.Options().Mounts) != 0 {
+		t.Fatalf("migrate: %+v, %v, %v", info, migrated, err)
+	}
+
+	// 当前版本不需要迁移
+	content, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, migrated, err := migrateInfo(content)
+	if err != nil || migrated || !reflect.DeepEqual(current, info) {
+		t.Errorf("expected %+v, got %+v, %v, %v", info, current, migrated, err)
+	}
+
+	if _, _, err := migrateInfo([]byte(`{"version":100}`)); err == nil {
+		t.Errorf("expected error for unknown version")
+	}
+}
+
+func TestMigrateLayout(t *testing.T) {
+	execRoot := t.TempDir()
+	containersPath := path.Join(execRoot, "containers")
+	for _, dir := range []string{"web", "db", "network/network", "containers/db"} {
+		if err := os.MkdirAll(path.Join(execRoot, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"web/config.json", "web/container.log", "db/config.json", "containers/db/config.json"} {
+		if err := os.WriteFile(path.Join(execRoot, file), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrateLayout(execRoot, containersPath)
+
+	if _, err := os.Stat(path.Join(containersPath, "web", "container.log")); err != nil {
+		t.Errorf("web not migrated: %v", err)
+	}
+	if _, err := os.Stat(path.Join(execRoot, "web")); !os.IsNotExist(err) {
+		t.Errorf("legacy web dir should be moved: %v", err)
+	}
+	// 同名的容器已经存在时不覆盖
+	if _, err := os.Stat(path.Join(execRoot, "db", "config.json")); err != nil {
+		t.Errorf("legacy db should be kept: %v", err)
+	}
+	if _, err := os.Stat(path.Join(execRoot, "network", "network")); err != nil {
+		t.Errorf("network dir should be kept: %v", err)
+	}
+}
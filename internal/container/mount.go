@@ -0,0 +1,454 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"godocker/internal/archive"
+	"godocker/internal/volume"
+	"godocker/pkg"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 挂载类型
+const (
+	MountTypeBind   = "bind"
+	MountTypeVolume = "volume"
+	MountTypeTmpfs  = "tmpfs"
+)
+
+// propagations bind-propagation 对应的挂载标志
+var propagations = map[string]uintptr{
+	"private":  syscall.MS_PRIVATE,
+	"rprivate": syscall.MS_PRIVATE | syscall.MS_REC,
+	"shared":   syscall.MS_SHARED,
+	"rshared":  syscall.MS_SHARED | syscall.MS_REC,
+	"slave":    syscall.MS_SLAVE,
+	"rslave":   syscall.MS_SLAVE | syscall.MS_REC,
+}
+
+// Mount 容器的一个挂载点，来自 -v 或者 --mount
+type Mount struct {
+	Type        string `json:"type"`
+	Source      string `json:"source,omitempty"` // 宿主机路径或者卷名，tmpfs 没有
+	Destination string `json:"destination"`
+	ReadOnly    bool   `json:"readonly,omitempty"`
+	Propagation string `json:"propagation,omitempty"`
+	Relabel     string `json:"relabel,omitempty"`   // z 或者 Z，目前只记录不处理
+	Data        string `json:"data,omitempty"`      // tmpfs 的挂载参数，例如 size=67108864,mode=1777
+	Anonymous   bool   `json:"anonymous,omitempty"` // 没有指定卷名的匿名卷，删除容器时一起删除
+}
+
+// ParseVolume 解析 -v 参数：host:ctr[:ro|rw][,z]、volume:ctr[:opts] 或者只有 ctr 的匿名卷
+func ParseVolume(spec string) (Mount, error) {
+	fields := strings.Split(spec, ":")
+	var m Mount
+	switch len(fields) {
+	case 1:
+		m.Destination = fields[0]
+	case 2, 3:
+		m.Source, m.Destination = fields[0], fields[1]
+	default:
+		return m, fmt.Errorf("invalid volume specification: %s", spec)
+	}
+
+	if len(fields) == 3 {
+		for _, opt := range strings.Split(fields[2], ",") {
+			switch {
+			case opt == "ro":
+				m.ReadOnly = true
+			case opt == "rw":
+				m.ReadOnly = false
+			case opt == "z" || opt == "Z":
+				m.Relabel = opt
+			case propagations[opt] != 0:
+				m.Propagation = opt
+			default:
+				return m, fmt.Errorf("invalid volume option %s in %s", opt, spec)
+			}
+		}
+	}
+
+	switch {
+	case m.Source == "":
+		m.Type, m.Source, m.Anonymous = MountTypeVolume, volume.RandomName(), true
+	case path.IsAbs(m.Source):
+		m.Type = MountTypeBind
+	case volume.IsName(m.Source):
+		m.Type = MountTypeVolume
+	default:
+		return m, fmt.Errorf("invalid volume source %s, host paths must be absolute", m.Source)
+	}
+
+	return m, m.validate()
+}
+
+// ParseMount 解析 --mount 参数：type=bind|volume|tmpfs,src=,dst=,readonly,bind-propagation=
+func ParseMount(spec string) (Mount, error) {
+	m := Mount{Type: MountTypeVolume}
+	var tmpfsOptions []string
+	for _, field := range strings.Split(spec, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		key, value := strings.ToLower(strings.TrimSpace(kv[0])), ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+
+		switch key {
+		case "type":
+			m.Type = value
+		case "src", "source":
+			m.Source = value
+		case "dst", "destination", "target":
+			m.Destination = value
+		case "readonly", "ro":
+			m.ReadOnly = value == "" || value == "1" || value == "true"
+		case "bind-propagation":
+			m.Propagation = value
+		case "tmpfs-size":
+			tmpfsOptions = append(tmpfsOptions, "size="+value)
+		case "tmpfs-mode":
+			tmpfsOptions = append(tmpfsOptions, "mode="+value)
+		case "tmpfs-options":
+			tmpfsOptions = append(tmpfsOptions, strings.Split(value, ":")...)
+		default:
+			return m, fmt.Errorf("unexpected key %s in mount %s", key, spec)
+		}
+	}
+
+	switch m.Type {
+	case MountTypeBind:
+		if !path.IsAbs(m.Source) {
+			return m, fmt.Errorf("bind source path must be absolute: %s", m.Source)
+		}
+		if _, err := os.Stat(m.Source); err != nil {
+			return m, fmt.Errorf("bind source path does not exist: %s", m.Source)
+		}
+	case MountTypeVolume:
+		if m.Source == "" {
+			m.Source, m.Anonymous = volume.RandomName(), true
+		}
+		if !volume.IsName(m.Source) {
+			return m, fmt.Errorf("invalid volume name %s", m.Source)
+		}
+	case MountTypeTmpfs:
+		if m.Source != "" {
+			return m, fmt.Errorf("source is not supported for tmpfs mounts")
+		}
+		data, readOnly, err := tmpfsData(tmpfsOptions)
+		if err != nil {
+			return m, err
+		}
+		m.Data, m.ReadOnly = data, m.ReadOnly || readOnly
+	default:
+		return m, fmt.Errorf("unsupported mount type %s", m.Type)
+	}
+	if len(tmpfsOptions) > 0 && m.Type != MountTypeTmpfs {
+		return m, fmt.Errorf("tmpfs options are only supported for tmpfs mounts")
+	}
+
+	return m, m.validate()
+}
+
+// ParseTmpfs 解析 --tmpfs 参数：/path[:size=64m,mode=1777,noexec,...]
+func ParseTmpfs(spec string) (Mount, error) {
+	m := Mount{Type: MountTypeTmpfs, Destination: spec}
+	if i := strings.Index(spec, ":"); i >= 0 {
+		m.Destination = spec[:i]
+		data, readOnly, err := tmpfsData(strings.Split(spec[i+1:], ","))
+		if err != nil {
+			return m, err
+		}
+		m.Data, m.ReadOnly = data, readOnly
+	}
+
+	return m, m.validate()
+}
+
+// tmpfsData 规范化 tmpfs 的挂载参数：size 使用和内存限制相同的单位解析为字节数，mode 必须是八进制，
+// ro/rw 转换为只读标志，noexec 等挂载标志原样保留，在挂载时再转换
+func tmpfsData(options []string) (string, bool, error) {
+	var data []string
+	readOnly := false
+	for _, opt := range options {
+		kv := strings.SplitN(opt, "=", 2)
+		switch {
+		case opt == "":
+			continue
+		case opt == "ro" || opt == "rw":
+			readOnly = opt == "ro"
+			continue
+		case kv[0] == "size" && len(kv) == 2:
+			size, err := pkg.ParseSize(kv[1])
+			if err != nil {
+				return "", false, fmt.Errorf("invalid tmpfs size: %v", err)
+			}
+			opt = fmt.Sprintf("size=%d", size)
+		case kv[0] == "mode" && len(kv) == 2:
+			mode, err := strconv.ParseUint(kv[1], 8, 32)
+			if err != nil || mode > 07777 {
+				return "", false, fmt.Errorf("invalid tmpfs mode %s", kv[1])
+			}
+			opt = fmt.Sprintf("mode=%o", mode)
+		}
+		data = append(data, opt)
+	}
+
+	return strings.Join(data, ","), readOnly, nil
+}
+
+// tmpfsFlags 挂载标志以外的参数传给 tmpfs，默认 nosuid、nodev
+func tmpfsFlags(data string, readOnly bool) (uintptr, string) {
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV)
+	if readOnly {
+		flags |= syscall.MS_RDONLY
+	}
+
+	var options []string
+	for _, opt := range strings.Split(data, ",") {
+		switch opt {
+		case "":
+		case "noexec":
+			flags |= syscall.MS_NOEXEC
+		case "exec":
+			flags &^= syscall.MS_NOEXEC
+		case "nosuid":
+			flags |= syscall.MS_NOSUID
+		case "suid":
+			flags &^= syscall.MS_NOSUID
+		case "nodev":
+			flags |= syscall.MS_NODEV
+		case "dev":
+			flags &^= syscall.MS_NODEV
+		default:
+			options = append(options, opt)
+		}
+	}
+
+	return flags, strings.Join(options, ",")
+}
+
+func (m Mount) validate() error {
+	if !path.IsAbs(m.Destination) {
+		return fmt.Errorf("mount destination %s is not an absolute path", m.Destination)
+	}
+	if path.Clean(m.Destination) == "/" {
+		return fmt.Errorf("invalid mount destination /")
+	}
+	if m.Propagation != "" {
+		if m.Type != MountTypeBind {
+			return fmt.Errorf("bind-propagation is only supported for bind mounts")
+		}
+		if propagations[m.Propagation] == 0 {
+			return fmt.Errorf("invalid bind-propagation %s", m.Propagation)
+		}
+	}
+
+	return nil
+}
+
+// hostPath 挂载源在宿主机上的路径
+func (m Mount) hostPath() string {
+	if m.Type == MountTypeVolume {
+		return volume.NewStore().Path(m.Source)
+	}
+
+	return m.Source
+}
+
+// sortMounts 按目标路径的深度排序，保证父目录先挂载
+func sortMounts(mounts []Mount) []Mount {
+	sorted := append([]Mount{}, mounts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return depth(sorted[i].Destination) < depth(sorted[j].Destination)
+	})
+
+	return sorted
+}
+
+func depth(p string) int {
+	return strings.Count(path.Clean(p), "/")
+}
+
+// prepareMounts 在父进程中准备挂载源：创建 -v 指定但不存在的宿主机目录，
+// 命名卷不存在时自动创建，并在第一次挂载时用镜像中挂载点的内容初始化
+func prepareMounts(mounts []Mount, rootfs, containerName string) (err error) {
+	store := volume.NewStore()
+	var acquired []Mount
+	defer func() {
+		// 出错时容器不会启动，释放这次获取的卷，匿名卷随之删除。重新启动的容器原来持有的引用保留
+		if err != nil {
+			releaseVolumes(acquired, containerName)
+		}
+	}()
+
+	for _, m := range mounts {
+		switch m.Type {
+		case MountTypeBind:
+			if _, err := os.Stat(m.Source); os.IsNotExist(err) {
+				if err := os.MkdirAll(m.Source, 0755); err != nil {
+					return fmt.Errorf("mkdir host volume dir %s error: %v", m.Source, err)
+				}
+			}
+		case MountTypeVolume:
+			acquire := store.Acquire
+			if m.Anonymous {
+				acquire = store.AcquireAnonymous
+			}
+			held := false
+			if v, err := store.Get(m.Source); err == nil {
+				held = v.Referenced(containerName)
+			}
+			v, err := acquire(m.Source, containerName)
+			if err != nil {
+				return fmt.Errorf("acquire volume %s error: %v", m.Source, err)
+			}
+			if !held {
+				acquired = append(acquired, m)
+			}
+			// 镜像中的挂载点可能是指向 rootfs 之外的符号链接，在 rootfs 中解析后再复制
+			src, err := archive.ResolveInScope(rootfs, m.Destination)
+			if err != nil {
+				return fmt.Errorf("resolve volume destination %s error: %v", m.Destination, err)
+			}
+			if err := store.Populate(v.Name, src); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// mountBinds 在容器的 mount namespace 中、pivot_root 之前挂载 bind 和 volume，rootfs 为新的根目录
+func mountBinds(mounts []Mount, rootfs string) error {
+	for _, m := range sortMounts(mounts) {
+		if m.Type == MountTypeTmpfs {
+			continue
+		}
+
+		source := m.hostPath()
+		target, err := mountTarget(rootfs, m.Destination)
+		if err != nil {
+			return err
+		}
+		if err := createMountPoint(source, target); err != nil {
+			return err
+		}
+
+		if err := syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind mount %s to %s error: %v", source, m.Destination, err)
+		}
+		// 只读需要在 bind 之后 remount，bind 时的 MS_RDONLY 会被内核忽略
+		if m.ReadOnly {
+			flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+			if err := syscall.Mount("", target, "", flags, ""); err != nil {
+				return fmt.Errorf("remount %s read-only error: %v", m.Destination, err)
+			}
+		}
+
+		propagation := m.Propagation
+		if propagation == "" {
+			propagation = "rprivate"
+		}
+		if err := syscall.Mount("", target, "", propagations[propagation], ""); err != nil {
+			return fmt.Errorf("set %s propagation of %s error: %v", propagation, m.Destination, err)
+		}
+	}
+
+	return nil
+}
+
+// mountTmpfs 在 pivot_root 之后挂载 tmpfs，内容不会进入读写层
+func mountTmpfs(mounts []Mount) error {
+	for _, m := range sortMounts(mounts) {
+		if m.Type != MountTypeTmpfs {
+			continue
+		}
+
+		if err := os.MkdirAll(m.Destination, 0755); err != nil {
+			return fmt.Errorf("mkdir tmpfs mount point %s error: %v", m.Destination, err)
+		}
+		flags, data := tmpfsFlags(m.Data, m.ReadOnly)
+		if err := syscall.Mount("tmpfs", m.Destination, "tmpfs", flags, data); err != nil {
+			return fmt.Errorf("mount tmpfs %s error: %v", m.Destination, err)
+		}
+	}
+
+	return nil
+}
+
+// mountTarget 挂载点在 rootfs 中的路径。挂载发生在 pivot_root 之前，镜像中的符号链接需要在 rootfs 中解析，
+// 否则会在宿主机上创建挂载点并把挂载源覆盖到宿主机的目录上
+func mountTarget(rootfs, destination string) (string, error) {
+	target, err := archive.ResolveInScope(rootfs, destination)
+	if err != nil {
+		return "", fmt.Errorf("resolve mount destination %s error: %v", destination, err)
+	}
+	rel, err := filepath.Rel(rootfs, target)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("mount destination %s resolves outside of the container rootfs", destination)
+	}
+
+	return target, nil
+}
+
+// createMountPoint 挂载源是文件时创建空文件作为挂载点，否则创建目录
+func createMountPoint(source, target string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return os.MkdirAll(target, 0755)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// umountMounts 卸载容器的挂载点并释放命名卷的引用。挂载点通常随容器的 mount namespace 一起消失，
+// 这里兼容已经卸载的情况，可以重复调用
+func umountMounts(mounts []Mount, rootfs, containerName string) {
+	sorted := sortMounts(mounts)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		m := sorted[i]
+		target, err := mountTarget(rootfs, m.Destination)
+		if err != nil {
+			logrus.Errorf("Umount container volume %s failed. %v", m.Destination, err)
+			continue
+		}
+		if err := syscall.Unmount(target, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL && err != syscall.ENOENT {
+			logrus.Errorf("Umount container volume %s failed. %v", target, err)
+		}
+	}
+
+	releaseVolumes(mounts, containerName)
+}
+
+// releaseVolumes 容器删除时释放对命名卷的引用
+func releaseVolumes(mounts []Mount, containerName string) {
+	store := volume.NewStore()
+	for _, m := range mounts {
+		if m.Type != MountTypeVolume {
+			continue
+		}
+		if err := store.Release(m.Source, containerName); err != nil {
+			logrus.Errorf("Release volume %s error: %v", m.Source, err)
+		}
+	}
+}
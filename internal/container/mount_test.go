@@ -0,0 +1,176 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"godocker/internal/config"
+	"godocker/internal/volume"
+)
+
+func TestParseVolume(t *testing.T) {
+	tests := map[string]Mount{
+		"/host:/ctr":         {Type: MountTypeBind, Source: "/host", Destination: "/ctr"},
+		"/host:/ctr:ro,z":    {Type: MountTypeBind, Source: "/host", Destination: "/ctr", ReadOnly: true, Relabel: "z"},
+		"/host:/ctr:rshared": {Type: MountTypeBind, Source: "/host", Destination: "/ctr", Propagation: "rshared"},
+		"data:/data:rw":      {Type: MountTypeVolume, Source: "data", Destination: "/data"},
+	}
+	for spec, expected := range tests {
+		m, err := ParseVolume(spec)
+		if err != nil {
+			t.Errorf("parse %s error: %v", spec, err)
+			continue
+		}
+		if m != expected {
+			t.Errorf("parse %s: expected %+v, got %+v", spec, expected, m)
+		}
+	}
+
+	if m, err := ParseVolume("/anonymous"); err != nil || m.Type != MountTypeVolume || m.Source == "" || !m.Anonymous {
+		t.Errorf("anonymous volume: %+v, %v", m, err)
+	}
+
+	for _, spec := range []string{"./relative:/ctr", "/host:ctr", "/host:/ctr:bad", "/a:/b:ro:x", "data:/data:rshared"} {
+		if _, err := ParseVolume(spec); err == nil {
+			t.Errorf("expected error for %s", spec)
+		}
+	}
+}
+
+func TestParseMount(t *testing.T) {
+	tests := map[string]Mount{
+		"type=bind,src=/tmp,dst=/ctr,readonly,bind-propagation=rslave": {
+			Type: MountTypeBind, Source: "/tmp", Destination: "/ctr", ReadOnly: true, Propagation: "rslave",
+		},
+		"type=volume,source=data,target=/data,readonly=false": {
+			Type: MountTypeVolume, Source: "data", Destination: "/data",
+		},
+		"type=tmpfs,dst=/run,tmpfs-size=64m,tmpfs-mode=1777": {
+			Type: MountTypeTmpfs, Destination: "/run", Data: "size=67108864,mode=1777",
+		},
+	}
+	for spec, expected := range tests {
+		m, err := ParseMount(spec)
+		if err != nil {
+			t.Errorf("parse %s error: %v", spec, err)
+			continue
+		}
+		if m != expected {
+			t.Errorf("parse %s: expected %+v, got %+v", spec, expected, m)
+		}
+	}
+
+	for _, spec := range []string{
+		"type=bind,src=relative,dst=/ctr",
+		"type=bind,src=/nonexistent-godocker,dst=/ctr",
+		"type=tmpfs,src=/tmp,dst=/ctr",
+		"type=volume,src=data,dst=/data,tmpfs-size=1m",
+		"type=volume,src=data,dst=/data,bind-propagation=shared",
+		"type=nfs,dst=/ctr",
+		"type=bind,src=/tmp,dst=/ctr,unknown=1",
+	} {
+		if _, err := ParseMount(spec); err == nil {
+			t.Errorf("expected error for %s", spec)
+		}
+	}
+}
+
+func TestParseTmpfs(t *testing.T) {
+	tests := map[string]Mount{
+		"/tmp":                              {Type: MountTypeTmpfs, Destination: "/tmp"},
+		"/tmp:size=64m,mode=1777":           {Type: MountTypeTmpfs, Destination: "/tmp", Data: "size=67108864,mode=1777"},
+		"/cache:ro,size=1g,noexec,uid=1000": {Type: MountTypeTmpfs, Destination: "/cache", ReadOnly: true, Data: "size=1073741824,noexec,uid=1000"},
+	}
+	for spec, expected := range tests {
+		m, err := ParseTmpfs(spec)
+		if err != nil {
+			t.Errorf("parse %s error: %v", spec, err)
+			continue
+		}
+		if m != expected {
+			t.Errorf("parse %s: expected %+v, got %+v", spec, expected, m)
+		}
+	}
+
+	for _, spec := range []string{"tmp", "/tmp:size=big", "/tmp:mode=999", "/tmp:mode=17777"} {
+		if _, err := ParseTmpfs(spec); err == nil {
+			t.Errorf("expected error for %s", spec)
+		}
+	}
+
+	flags, data := tmpfsFlags("size=1024,noexec,uid=1000,dev", true)
+	if flags != syscall.MS_NOSUID|syscall.MS_NOEXEC|syscall.MS_RDONLY || data != "size=1024,uid=1000" {
+		t.Errorf("tmpfs flags: %x, %s", flags, data)
+	}
+}
+
+func TestMountTarget(t *testing.T) {
+	rootfs := t.TempDir()
+	host := t.TempDir()
+	for name, target := range map[string]string{"data": host, "etc": "/", "app": "../../opt/app"} {
+		if err := os.Symlink(target, filepath.Join(rootfs, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 指向宿主机目录的绝对路径链接在 rootfs 中解析
+	target, err := mountTarget(rootfs, "/data/conf")
+	if err != nil || target != filepath.Join(rootfs, host, "conf") {
+		t.Fatalf("unexpected target %s, %v", target, err)
+	}
+	source := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(source, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := createMountPoint(source, target); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(host, "conf")); !os.IsNotExist(err) {
+		t.Errorf("mount point created on host: %v", err)
+	}
+
+	if target, err := mountTarget(rootfs, "/app"); err != nil || target != filepath.Join(rootfs, "opt/app") {
+		t.Errorf("unexpected target %s, %v", target, err)
+	}
+	if _, err := mountTarget(rootfs, "/etc"); err == nil {
+		t.Error("expected error for destination resolving to the rootfs")
+	}
+}
+
+func TestPrepareMountsRelease(t *testing.T) {
+	previous := config.Get()
+	config.Set(&config.Config{Root: t.TempDir(), ExecRoot: t.TempDir(), CgroupDriver: config.DefaultCgroupDriver})
+	defer config.Set(previous)
+
+	store := volume.NewStore()
+	// 重新启动的容器已经持有 data 的引用
+	if _, err := store.Acquire("data", "web"); err != nil {
+		t.Fatal(err)
+	}
+	rootfs := t.TempDir()
+	if err := os.Symlink("/loop", filepath.Join(rootfs, "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	mounts := []Mount{
+		{Type: MountTypeVolume, Source: "anon", Destination: "/anon", Anonymous: true},
+		{Type: MountTypeVolume, Source: "new", Destination: "/new"},
+		{Type: MountTypeVolume, Source: "data", Destination: "/data"},
+		{Type: MountTypeVolume, Source: "bad", Destination: "/loop"},
+	}
+	if err := prepareMounts(mounts, rootfs, "web"); err == nil {
+		t.Fatal("expected error for symlink loop")
+	}
+
+	if _, err := store.Get("anon"); err == nil {
+		t.Errorf("anonymous volume should be removed")
+	}
+	for name, referenced := range map[string]bool{"new": false, "bad": false, "data": true} {
+		v, err := store.Get(name)
+		if err != nil || v.Referenced("web") != referenced {
+			t.Errorf("%s: expected referenced %v, got %+v, %v", name, referenced, v, err)
+		}
+	}
+}
@@ -1,23 +1,36 @@
 package container
 
 import (
-	"fmt"
-
 	"godocker/internal/cgroup/subsystem"
+	"godocker/internal/image"
+
+	"github.com/sirupsen/logrus"
 )
 
 type Option func(opts *Options)
 
+// Options 创建容器的参数，完整保存在容器信息的 config 中，start 时据此重新创建容器
 type Options struct {
-	Name           string
-	Image          string
-	TTY            bool
-	Detach         bool
-	Volume         string
-	Network        string
-	Envs           []string
-	PortMapping    []string
-	ResourceConfig *subsystem.ResourceConfig
+	Name           string                    `json:"name"`
+	Image          string                    `json:"image"`
+	ImageID        string                    `json:"image_id"`
+	Hostname       string                    `json:"hostname,omitempty"`
+	WorkingDir     string                    `json:"working_dir,omitempty"`
+	User           string                    `json:"user,omitempty"`
+	StopSignal     string                    `json:"stop_signal,omitempty"`
+	TTY            bool                      `json:"tty"`
+	Detach         bool                      `json:"detach"`
+	Mounts         []Mount                   `json:"mounts,omitempty"`
+	ReadOnly       bool                      `json:"read_only,omitempty"`
+	StorageDriver  string                    `json:"storage_driver,omitempty"`
+	StorageOpt     map[string]string         `json:"storage_opt,omitempty"`
+	Network        string                    `json:"network,omitempty"`
+	Envs           []string                  `json:"env,omitempty"`
+	PortMapping    []string                  `json:"port_mapping,omitempty"`
+	Labels         map[string]string         `json:"labels,omitempty"`
+	CgroupParent   string                    `json:"cgroup_parent,omitempty"`
+	RestartPolicy  RestartPolicy             `json:"restart_policy"`
+	ResourceConfig *subsystem.ResourceConfig `json:"resources,omitempty"`
 }
 
 func NewOptions() *Options {
@@ -29,7 +42,6 @@ func (o *Options) Apply(opts ...Option) *Options {
 		opt(o)
 	}
 
-	fmt.Println(o)
 	return o
 }
 
@@ -39,9 +51,27 @@ func WithResourceConfig(resourceConfig *subsystem.ResourceConfig) Option {
 	}
 }
 
-func WithVolume(volume string) Option {
+func WithMounts(mounts []Mount) Option {
+	return func(opts *Options) {
+		opts.Mounts = append(opts.Mounts, mounts...)
+	}
+}
+
+func WithHostname(hostname string) Option {
 	return func(opts *Options) {
-		opts.Volume = volume
+		opts.Hostname = hostname
+	}
+}
+
+func WithLabels(labels map[string]string) Option {
+	return func(opts *Options) {
+		opts.Labels = labels
+	}
+}
+
+func WithReadOnly(readOnly bool) Option {
+	return func(opts *Options) {
+		opts.ReadOnly = readOnly
 	}
 }
 
@@ -63,9 +93,17 @@ func WithContainerName(containerName string) Option {
 	}
 }
 
-func WithImage(image string) Option {
+// WithImage 通过镜像存储解析 name:tag、镜像 ID 或者镜像 ID 前缀，解析失败时 ImageID 为空
+func WithImage(ref string) Option {
 	return func(opts *Options) {
-		opts.Image = image
+		opts.Image = ref
+
+		img, err := image.NewStore().Resolve(ref)
+		if err != nil {
+			logrus.Errorf("Resolve image %s error: %v", ref, err)
+			return
+		}
+		opts.ImageID = img.ID
 	}
 }
 
@@ -86,3 +124,27 @@ func WithPortMapping(portMapping []string) Option {
 		opts.PortMapping = portMapping
 	}
 }
+
+func WithStorageDriver(storageDriver string) Option {
+	return func(opts *Options) {
+		opts.StorageDriver = storageDriver
+	}
+}
+
+func WithRestartPolicy(policy RestartPolicy) Option {
+	return func(opts *Options) {
+		opts.RestartPolicy = policy
+	}
+}
+
+func WithCgroupParent(cgroupParent string) Option {
+	return func(opts *Options) {
+		opts.CgroupParent = cgroupParent
+	}
+}
+
+func WithStorageOpt(storageOpt map[string]string) Option {
+	return func(opts *Options) {
+		opts.StorageOpt = storageOpt
+	}
+}
@@ -0,0 +1,70 @@
+package container
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PauseContainer 使用 freezer 冻结容器 cgroup 中的所有进程
+func PauseContainer(name string) error {
+	containerInfo, err := GetContainerInfo(name)
+	if err != nil {
+		return fmt.Errorf("no such container: %s", name)
+	}
+	if containerInfo.Status == Paused {
+		return fmt.Errorf("container %s is already paused", name)
+	}
+	if !containerInfo.Running() {
+		return fmt.Errorf("container %s is not running", name)
+	}
+	if containerInfo.CgroupPath == "" {
+		return fmt.Errorf("container %s has no cgroup, restart it before pausing", name)
+	}
+
+	if err := containerInfo.CGroup().Freeze(); err != nil {
+		return err
+	}
+
+	return setStatus(name, Paused)
+}
+
+// UnpauseContainer 恢复被冻结的容器
+func UnpauseContainer(name string) error {
+	containerInfo, err := GetContainerInfo(name)
+	if err != nil {
+		return fmt.Errorf("no such container: %s", name)
+	}
+	if containerInfo.Status != Paused || !containerInfo.Running() {
+		return fmt.Errorf("container %s is not paused", name)
+	}
+
+	if err := containerInfo.CGroup().Thaw(); err != nil {
+		return err
+	}
+
+	return setStatus(name, Running)
+}
+
+// setStatus 在 pause/unpause 期间容器可能已经退出，只修改仍在运行的容器的状态
+func setStatus(name string, status Status) error {
+	_, err := ModifyContainerInfo(name, func(containerInfo *Info) error {
+		if !containerInfo.Running() {
+			return fmt.Errorf("container %s is not running", name)
+		}
+		containerInfo.Status = status
+		return nil
+	})
+	return err
+}
+
+// thawIfPaused 停止容器前先恢复被冻结的进程，否则进程无法处理信号并退出
+func thawIfPaused(containerInfo *Info) {
+	if containerInfo.Status != Paused {
+		return
+	}
+
+	if err := containerInfo.CGroup().Thaw(); err != nil {
+		logrus.Errorf("Thaw container %s error %v", containerInfo.Name, err)
+	}
+}
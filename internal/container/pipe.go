@@ -1,25 +1,44 @@
 package container
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
-	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
-func WriteInitCommand(comArray []string, w *os.File) {
-	command := strings.Join(comArray, " ")
-	_, _ = w.WriteString(command)
+// InitConfig 父进程通过管道传递给容器 init 进程的配置
+type InitConfig struct {
+	Args       []string `json:"args"`
+	Hostname   string   `json:"hostname,omitempty"`
+	WorkingDir string   `json:"working_dir,omitempty"`
+	User       string   `json:"user,omitempty"`
+	Mounts     []Mount  `json:"mounts,omitempty"`
+	ReadOnly   bool     `json:"read_only,omitempty"`
+}
+
+func WriteInitConfig(initConfig *InitConfig, w *os.File) {
+	content, err := json.Marshal(initConfig)
+	if err != nil {
+		logrus.Errorf("Marshal init config error: %v", err)
+	}
+	_, _ = w.Write(content)
 	_ = w.Close()
 }
 
-func readInitCommand() []string {
+func readInitConfig() (*InitConfig, error) {
 	f := os.NewFile(uintptr(3), "pipe")
 	content, err := ioutil.ReadAll(f)
 	if err != nil {
-		logrus.Errorf("Init read command error: %v", err)
+		return nil, fmt.Errorf("init read config error: %v", err)
+	}
+
+	var initConfig InitConfig
+	if err := json.Unmarshal(content, &initConfig); err != nil {
+		return nil, fmt.Errorf("init unmarshal config error: %v", err)
 	}
 
-	return strings.Split(string(content), " ")
+	return &initConfig, nil
 }
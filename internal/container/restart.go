@@ -0,0 +1,83 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 重启策略
+const (
+	RestartPolicyNo            = "no"
+	RestartPolicyAlways        = "always"
+	RestartPolicyOnFailure     = "on-failure"
+	RestartPolicyUnlessStopped = "unless-stopped"
+)
+
+// 重启间隔从 100ms 开始每次翻倍，最长 1 分钟；容器运行超过 10 秒后重新从 100ms 开始
+const (
+	restartDelayMin   = 100 * time.Millisecond
+	restartDelayMax   = time.Minute
+	restartResetAfter = 10 * time.Second
+)
+
+// RestartPolicy 容器退出后由 shim 按策略重新启动，MaximumRetryCount 只对 on-failure 有效，0 表示不限制
+type RestartPolicy struct {
+	Name              string `json:"name"`
+	MaximumRetryCount int    `json:"maximum_retry_count,omitempty"`
+}
+
+// ParseRestartPolicy 解析 --restart 的参数：no、always、unless-stopped、on-failure[:max]
+func ParseRestartPolicy(policy string) (RestartPolicy, error) {
+	name, count, hasCount := strings.Cut(policy, ":")
+	switch name {
+	case "", RestartPolicyNo:
+		name = RestartPolicyNo
+	case RestartPolicyAlways, RestartPolicyUnlessStopped, RestartPolicyOnFailure:
+	default:
+		return RestartPolicy{}, fmt.Errorf("invalid restart policy %q", policy)
+	}
+
+	if !hasCount {
+		return RestartPolicy{Name: name}, nil
+	}
+	if name != RestartPolicyOnFailure {
+		return RestartPolicy{}, fmt.Errorf("maximum retry count can only be used with %s", RestartPolicyOnFailure)
+	}
+	max, err := strconv.Atoi(count)
+	if err != nil || max < 0 {
+		return RestartPolicy{}, fmt.Errorf("invalid maximum retry count %q", count)
+	}
+
+	return RestartPolicy{Name: name, MaximumRetryCount: max}, nil
+}
+
+// ShouldRestart 容器退出后是否需要重新启动，被 stop/kill 停止的容器不再重启。
+// 没有常驻的守护进程，always 和 unless-stopped 的行为相同。
+func (p RestartPolicy) ShouldRestart(exitCode, restartCount int, manuallyStopped bool) bool {
+	if manuallyStopped {
+		return false
+	}
+
+	switch p.Name {
+	case RestartPolicyAlways, RestartPolicyUnlessStopped:
+		return true
+	case RestartPolicyOnFailure:
+		return exitCode != 0 && (p.MaximumRetryCount == 0 || restartCount < p.MaximumRetryCount)
+	}
+
+	return false
+}
+
+// NextRestartDelay 根据上一次的间隔和容器运行的时间计算下一次重启前等待的时间
+func NextRestartDelay(delay, running time.Duration) time.Duration {
+	if delay == 0 || running >= restartResetAfter {
+		return restartDelayMin
+	}
+	if delay *= 2; delay > restartDelayMax {
+		return restartDelayMax
+	}
+
+	return delay
+}
@@ -0,0 +1,60 @@
+package container
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	tests := map[string]RestartPolicy{
+		"":               {Name: RestartPolicyNo},
+		"no":             {Name: RestartPolicyNo},
+		"always":         {Name: RestartPolicyAlways},
+		"unless-stopped": {Name: RestartPolicyUnlessStopped},
+		"on-failure":     {Name: RestartPolicyOnFailure},
+		"on-failure:3":   {Name: RestartPolicyOnFailure, MaximumRetryCount: 3},
+	}
+	for policy, expected := range tests {
+		actual, err := ParseRestartPolicy(policy)
+		if err != nil || actual != expected {
+			t.Errorf("parse %q: expected %+v, got %+v, %v", policy, expected, actual, err)
+		}
+	}
+
+	for _, policy := range []string{"sometimes", "always:3", "on-failure:", "on-failure:-1", "on-failure:x"} {
+		if _, err := ParseRestartPolicy(policy); err == nil {
+			t.Errorf("expected error for %q", policy)
+		}
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	onFailure := RestartPolicy{Name: RestartPolicyOnFailure, MaximumRetryCount: 2}
+	if !onFailure.ShouldRestart(1, 1, false) || onFailure.ShouldRestart(1, 2, false) || onFailure.ShouldRestart(0, 0, false) {
+		t.Errorf("unexpected on-failure result")
+	}
+
+	always := RestartPolicy{Name: RestartPolicyAlways}
+	if !always.ShouldRestart(0, 100, false) || always.ShouldRestart(0, 0, true) {
+		t.Errorf("unexpected always result")
+	}
+
+	if (RestartPolicy{}).ShouldRestart(1, 0, false) {
+		t.Errorf("empty policy should not restart")
+	}
+}
+
+func TestNextRestartDelay(t *testing.T) {
+	delay := NextRestartDelay(0, 0)
+	for _, expected := range []time.Duration{200 * time.Millisecond, 400 * time.Millisecond} {
+		if delay = NextRestartDelay(delay, time.Second); delay != expected {
+			t.Errorf("expected %v, got %v", expected, delay)
+		}
+	}
+	if delay = NextRestartDelay(delay, time.Minute); delay != restartDelayMin {
+		t.Errorf("expected delay reset, got %v", delay)
+	}
+	if delay = NextRestartDelay(restartDelayMax, time.Second); delay != restartDelayMax {
+		t.Errorf("expected max delay, got %v", delay)
+	}
+}
@@ -0,0 +1,111 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	passwdFile = "/etc/passwd"
+	groupFile  = "/etc/group"
+)
+
+// setUser 切换到镜像 USER 指定的用户，支持 user、uid、user:group、uid:gid，
+// 必须在 pivot_root 之后调用，用户名从容器内的 /etc/passwd 和 /etc/group 中查找
+func setUser(user string) error {
+	uid, gid, err := lookupUser(user)
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("setgroups error: %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid %d error: %v", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid %d error: %v", uid, err)
+	}
+
+	return nil
+}
+
+// lookupUser 解析用户名和用户组，未指定用户组时使用用户的主组
+func lookupUser(user string) (int, int, error) {
+	name, group := user, ""
+	if i := strings.Index(user, ":"); i >= 0 {
+		name, group = user[:i], user[i+1:]
+	}
+
+	uid, gid := -1, 0
+	if id, err := strconv.Atoi(name); err == nil {
+		uid = id
+	}
+	// passwd: name:password:uid:gid:gecos:home:shell
+	err := scanIDFile(passwdFile, func(fields []string) bool {
+		if len(fields) < 4 || (fields[0] != name && fields[2] != name) {
+			return false
+		}
+		uid, _ = strconv.Atoi(fields[2])
+		gid, _ = strconv.Atoi(fields[3])
+		return true
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+	if uid < 0 {
+		return 0, 0, fmt.Errorf("unable to find user %s", name)
+	}
+
+	if group == "" {
+		return uid, gid, nil
+	}
+	if id, err := strconv.Atoi(group); err == nil {
+		return uid, id, nil
+	}
+	// group: name:password:gid:members
+	found := false
+	err = scanIDFile(groupFile, func(fields []string) bool {
+		if len(fields) < 3 || fields[0] != group {
+			return false
+		}
+		gid, _ = strconv.Atoi(fields[2])
+		found = true
+		return true
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+	if !found {
+		return 0, 0, fmt.Errorf("unable to find group %s", group)
+	}
+
+	return uid, gid, nil
+}
+
+// scanIDFile 逐行读取 passwd/group 格式的文件，match 返回 true 时停止
+func scanIDFile(file string, match func(fields []string) bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if match(strings.Split(line, ":")) {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
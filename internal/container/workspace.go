@@ -2,114 +2,69 @@ package container
 
 import (
 	"fmt"
-	"os"
-	"os/exec"
-	"path"
 
-	"godocker/pkg"
+	"godocker/internal/config"
+	"godocker/internal/image"
+	"godocker/internal/storage"
 
 	"github.com/sirupsen/logrus"
 )
 
-func NewWorkSpace(volume, imageName, containerName string) {
-	createReadOnlyLayer(imageName)
-	createWriteLayer(containerName)
-	createMountPoint(containerName, imageName)
-
-	if volume != "" {
-		volumes := volumeUrlExtract(volume)
-		if len(volumes) == 2 && volumes[0] != "" && volumes[1] != "" {
-			mountVolume(volumes, containerName)
-		} else {
-			logrus.Infof("Volume parameter input is not correct.")
-		}
+// storageDriver 返回容器使用的存储驱动，name 为空时使用当前主机默认的驱动
+func storageDriver(name string) (storage.StorageDriver, error) {
+	if name == "" {
+		return storage.Default(config.Get().Root)
 	}
-}
 
-// createReadOnlyLayer Create container readonly layer
-// 1. mkdir container dir.
-// 2. unTar image to container readonly dir
-func createReadOnlyLayer(imageName string) {
-	target := path.Join(rootPath, imageName)
-	source := fmt.Sprintf("%s/%s.tar", rootPath, imageName)
+	return storage.New(name, config.Get().Root)
+}
 
-	exist, err := pkg.PathExists(target)
+// NewWorkSpace 创建容器的 rootfs 并准备挂载源，返回 rootfs 路径
+// mounts imageID containerName storageDriver storageOpt
+func NewWorkSpace(mounts []Mount, imageID, containerName, driverName string, storageOpt map[string]string) (string, error) {
+	driver, err := storageDriver(driverName)
 	if err != nil {
-		logrus.Infof("Fail to judge whether dir %s exists. %v", target, err)
-	}
-
-	if !exist {
-		if err := os.Mkdir(target, 0755); err != nil {
-			logrus.Errorf("Mkdir dir %s error. %v", target, err)
-		}
+		return "", err
 	}
 
-	if _, err := exec.Command("tar", "-xvf", source, "-C", target).CombinedOutput(); err != nil {
-		logrus.Errorf("Untar dir %s error %v", source, target)
+	// 镜像的每一层只解压一次，作为只读层被所有容器共享
+	store := image.NewStore()
+	img, err := store.Get(imageID)
+	if err != nil {
+		return "", err
 	}
-}
-
-// createWriteLayer Create container write layer.
-func createWriteLayer(containerName string) {
-	target := fmt.Sprintf(wirtePath, containerName)
-	if err := os.Mkdir(target, 0777); err != nil {
-		logrus.Infof("Mkdir writelayer dir %s error: %v", target, err)
+	if err := driver.Create(containerName, storageOpt); err != nil {
+		return "", fmt.Errorf("create write layer error: %v", err)
 	}
-}
 
-// createMountPoint
-func createMountPoint(containerName, imageName string) {
-	if err := os.Mkdir(fmt.Sprintf(mntPath, containerName), 0777); err != nil {
-		logrus.Infof("Mkdir mount path: %s, error: %v", mntPath, err)
-	}
-
-	// fmt.Sprintf("dirs=%writeLayer:%sbusybox", rootPath, rootPath)
-	dirs := "dirs=" + fmt.Sprintf(wirtePath, containerName) + ":" + path.Join(rootPath, imageName)
-	logrus.Infof("only dirs: %s, mnt dir: %s", dirs, fmt.Sprintf(mntPath, containerName))
-	cmd := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", fmt.Sprintf(mntPath, containerName))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		logrus.Errorf("Mount point error: %v", err)
+	rootfs, err := driver.Mount(containerName, store.LayerDirs(img))
+	if err != nil {
+		return "", err
 	}
-}
+	logrus.Infof("storage driver: %s, rootfs: %s", driver.Name(), rootfs)
 
-// volume imageName containerName
-func RemoveWorkSpace(volume, containerName string) {
-	if volume != "" {
-		volumes := volumeUrlExtract(volume)
-		if len(volumes) == 2 && volumes[0] != "" && volumes[1] != "" {
-			umountVolume(volumes, containerName)
-		}
+	// 挂载本身在容器的 mount namespace 中进行，见 setUpMount
+	if err := prepareMounts(mounts, rootfs, containerName); err != nil {
+		return "", err
 	}
 
-	removeMountPoint(containerName)
-	// removeReadOnlyLayer(rootPath)
-	removeWriteLayer(containerName)
+	return rootfs, nil
 }
 
-func removeReadOnlyLayer(imageName string) {
-	dir := path.Join(rootPath, "busybox")
-	if err := os.RemoveAll(dir); err != nil {
-		logrus.Infof("Remove busybox dir %s error: %v", dir, err)
+// mounts containerName storageDriver
+func RemoveWorkSpace(mounts []Mount, containerName, driverName string) {
+	driver, err := storageDriver(driverName)
+	if err != nil {
+		logrus.Errorf("Get storage driver error: %v", err)
+		return
 	}
-}
 
-func removeWriteLayer(containerName string) {
-	dir := fmt.Sprintf(wirtePath, containerName) // path.Join(rootPath, "writeLayer")
-	if err := os.RemoveAll(dir); err != nil {
-		logrus.Infof("Remove writelayer dir %s error: %v", dir, err)
-	}
-}
+	umountMounts(mounts, driver.Path(containerName), containerName)
 
-func removeMountPoint(containerName string) {
-	cmd := exec.Command("umount", fmt.Sprintf(mntPath, containerName))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		logrus.Errorf("Umount dir %s error %v", fmt.Sprintf(mntPath, containerName), err)
+	if err := driver.Unmount(containerName); err != nil {
+		logrus.Errorf("Umount rootfs %s error %v", containerName, err)
 	}
-	if err := os.RemoveAll(fmt.Sprintf(mntPath, containerName)); err != nil {
-		logrus.Infof("Remove mount dir %s error: %v", fmt.Sprintf(mntPath, containerName), err)
+	if err := driver.Remove(containerName); err != nil {
+		logrus.Infof("Remove write layer %s error: %v", containerName, err)
 	}
 }
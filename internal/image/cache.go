@@ -0,0 +1,76 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+const buildCacheFile = "buildcache.json"
+
+// CacheKey 计算构建缓存的 key：父镜像 ID 加上指令，
+// COPY/ADD 等依赖外部内容的指令通过 extra 传入内容的摘要
+func CacheKey(parentID, instruction string, extra ...string) string {
+	h := sha256.New()
+	h.Write([]byte(parentID + "\n" + instruction))
+	for _, e := range extra {
+		h.Write([]byte("\n" + e))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// CacheLookup 查找构建缓存，命中且镜像仍然存在时返回镜像
+func (s *Store) CacheLookup(key string) (*Image, bool) {
+	cache, err := s.loadCache()
+	if err != nil {
+		return nil, false
+	}
+	id, ok := cache[key]
+	if !ok {
+		return nil, false
+	}
+	img, err := s.load(id)
+	if err != nil {
+		return nil, false
+	}
+
+	return img, true
+}
+
+// CacheStore 记录构建缓存，同时清理指向已删除镜像的记录
+func (s *Store) CacheStore(key, id string) error {
+	cache, err := s.loadCache()
+	if err != nil {
+		return err
+	}
+	for k, imageID := range cache {
+		if _, err := os.Stat(s.imagePath(imageID)); err != nil {
+			delete(cache, k)
+		}
+	}
+	cache[key] = id
+
+	content, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path.Join(s.Root, buildCacheFile), content)
+}
+
+func (s *Store) loadCache() (map[string]string, error) {
+	cache := make(map[string]string)
+	content, err := ioutil.ReadFile(path.Join(s.Root, buildCacheFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, err
+	}
+
+	return cache, json.Unmarshal(content, &cache)
+}
@@ -0,0 +1,177 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"godocker/pkg"
+)
+
+// ParseInstruction 将 Dockerfile 风格的指令拆分为大写的指令名和参数
+func ParseInstruction(line string) (string, string, error) {
+	line = strings.TrimSpace(line)
+	fields := strings.SplitN(line, " ", 2)
+	if len(fields) != 2 || strings.TrimSpace(fields[1]) == "" {
+		return "", "", fmt.Errorf("invalid instruction: %s", line)
+	}
+
+	return strings.ToUpper(fields[0]), strings.TrimSpace(fields[1]), nil
+}
+
+// ParseCommand 解析 CMD/ENTRYPOINT 的参数，支持 JSON 数组和 shell 两种形式
+func ParseCommand(args string) []string {
+	var command []string
+	if strings.HasPrefix(args, "[") && json.Unmarshal([]byte(args), &command) == nil {
+		return command
+	}
+
+	return []string{"/bin/sh", "-c", args}
+}
+
+// ParseEnv 解析 ENV 的参数，支持 ENV key=value key2=value2 和 ENV key value 两种形式
+func ParseEnv(args string) ([]string, error) {
+	if !strings.Contains(strings.SplitN(args, " ", 2)[0], "=") {
+		fields := strings.SplitN(args, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("ENV %s need a value", args)
+		}
+		return []string{fields[0] + "=" + strings.TrimSpace(fields[1])}, nil
+	}
+
+	var envs []string
+	for _, field := range strings.Fields(args) {
+		if !strings.Contains(field, "=") {
+			return nil, fmt.Errorf("invalid ENV %s", field)
+		}
+		envs = append(envs, strings.Trim(field, `"`))
+	}
+
+	return envs, nil
+}
+
+// SetEnv 设置环境变量，已存在的同名变量会被覆盖
+func (c *Config) SetEnv(envs ...string) {
+	for _, env := range envs {
+		key := strings.SplitN(env, "=", 2)[0]
+		replaced := false
+		for i, existed := range c.Env {
+			if strings.SplitN(existed, "=", 2)[0] == key {
+				c.Env[i] = env
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			c.Env = append(c.Env, env)
+		}
+	}
+}
+
+// ParseKeyValues 解析 LABEL 的参数，支持 LABEL key=value key2="value 2" 和 LABEL key value 两种形式
+func ParseKeyValues(args string) (map[string]string, error) {
+	values := make(map[string]string)
+	if !strings.Contains(strings.SplitN(args, " ", 2)[0], "=") {
+		fields := strings.SplitN(args, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s need a value", args)
+		}
+		values[fields[0]] = strings.Trim(strings.TrimSpace(fields[1]), `"`)
+		return values, nil
+	}
+
+	for _, field := range splitQuoted(args) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid key value %s", field)
+		}
+		values[strings.Trim(kv[0], `"`)] = strings.Trim(kv[1], `"`)
+	}
+
+	return values, nil
+}
+
+// splitQuoted 按空白拆分，双引号内的空白不拆分
+func splitQuoted(s string) []string {
+	var fields []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case (r == ' ' || r == '\t') && !quoted:
+			if current.Len() > 0 {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		fields = append(fields, current.String())
+	}
+
+	return fields
+}
+
+// ApplyChange 将 Dockerfile 中只修改运行配置的指令应用到配置上，commit --change 和 build 共用
+func (c *Config) ApplyChange(change string) error {
+	instruction, args, err := ParseInstruction(change)
+	if err != nil {
+		return err
+	}
+
+	switch instruction {
+	case "ENV":
+		envs, err := ParseEnv(args)
+		if err != nil {
+			return err
+		}
+		c.SetEnv(envs...)
+	case "CMD":
+		c.Cmd = ParseCommand(args)
+	case "ENTRYPOINT":
+		c.Entrypoint = ParseCommand(args)
+	case "WORKDIR":
+		if !path.IsAbs(args) {
+			args = path.Join("/", c.WorkingDir, args)
+		}
+		c.WorkingDir = path.Clean(args)
+	case "USER":
+		c.User = args
+	case "EXPOSE":
+		if c.ExposedPorts == nil {
+			c.ExposedPorts = make(map[string]struct{})
+		}
+		for _, port := range strings.Fields(args) {
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			c.ExposedPorts[port] = struct{}{}
+		}
+	case "LABEL":
+		labels, err := ParseKeyValues(args)
+		if err != nil {
+			return err
+		}
+		if c.Labels == nil {
+			c.Labels = make(map[string]string)
+		}
+		for k, v := range labels {
+			c.Labels[k] = v
+		}
+	case "STOPSIGNAL":
+		if _, err := pkg.ParseSignal(args); err != nil {
+			return err
+		}
+		c.StopSignal = args
+	default:
+		return fmt.Errorf("unsupported change instruction: %s", instruction)
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package image
+
+import (
+	"time"
+)
+
+// HistoryItem 镜像构建记录中的一步
+type HistoryItem struct {
+	ImageID   string // 这一步产生的镜像，父镜像链之外的步骤为空
+	Layer     string // 这一步产生的层，EmptyLayer 时为空
+	Size      int64
+	Created   time.Time
+	CreatedBy string
+	Comment   string
+}
+
+// History 返回镜像的构建记录，最新的一步在前
+func (s *Store) History(ref string) ([]HistoryItem, error) {
+	img, err := s.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	history := img.History
+	// 缺少构建记录的层（例如从其他工具导入的镜像）补充空记录，使每一层都有对应的记录
+	layers := 0
+	for _, h := range history {
+		if !h.EmptyLayer {
+			layers++
+		}
+	}
+	for i := layers; i < len(img.RootFS.DiffIDs); i++ {
+		history = append([]History{{}}, history...)
+	}
+
+	items := make([]HistoryItem, len(history))
+	layerRows := make(map[int]int) // 层序号 -> 产生该层的记录
+	layer := 0
+	for i, h := range history {
+		items[i] = HistoryItem{
+			Created:   h.Created,
+			CreatedBy: h.CreatedBy,
+			Comment:   h.Comment,
+		}
+		if !h.EmptyLayer && layer < len(img.RootFS.DiffIDs) {
+			items[i].Layer = img.RootFS.DiffIDs[layer]
+			if l, err := s.Layer(items[i].Layer); err == nil {
+				items[i].Size = l.Size
+				if items[i].Created.IsZero() {
+					items[i].Created = l.Created
+				}
+			}
+			layerRows[layer] = i
+			layer++
+		}
+	}
+
+	// 沿父镜像链标记每个镜像的最后一步，没有构建记录的镜像按层数定位
+	padding := len(history) - len(img.History)
+	for current := img; current != nil; {
+		if n := len(current.History); n > 0 {
+			if i := n - 1 + padding; i >= 0 && i < len(items) {
+				items[i].ImageID = current.ID
+			}
+		} else if i, ok := layerRows[len(current.RootFS.DiffIDs)-1]; ok {
+			items[i].ImageID = current.ID
+		}
+
+		if current.Parent == "" {
+			break
+		}
+		if current, err = s.load(current.Parent); err != nil {
+			break
+		}
+	}
+
+	// 最新的一步在前
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+
+	return items, nil
+}
@@ -0,0 +1,117 @@
+package image
+
+import (
+	"time"
+)
+
+// Image 镜像配置，格式与 OCI image config 一致，镜像 ID 为该配置 JSON 的 sha256
+type Image struct {
+	ID           string    `json:"-"`
+	Parent       string    `json:"parent,omitempty"`
+	Created      time.Time `json:"created"`
+	Author       string    `json:"author,omitempty"`
+	Comment      string    `json:"comment,omitempty"`
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Config       Config    `json:"config"`
+	RootFS       RootFS    `json:"rootfs"`
+	History      []History `json:"history,omitempty"`
+}
+
+// Config 容器运行时的默认配置
+type Config struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+// RootFS 镜像的层，DiffIDs 按从下到上的顺序排列
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// History 镜像每一步的构建记录，EmptyLayer 为 true 时这一步没有产生新的层
+type History struct {
+	Created    time.Time `json:"created"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	Author     string    `json:"author,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	EmptyLayer bool      `json:"empty_layer,omitempty"`
+}
+
+// Layer 镜像层元数据，Digest 为未压缩 tar 的 sha256
+type Layer struct {
+	Digest  string    `json:"digest"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// Child 以 img 为父镜像创建新镜像，复制运行配置、层和构建记录
+func (img *Image) Child() *Image {
+	child := &Image{
+		Parent:       img.ID,
+		Created:      time.Now().UTC(),
+		Architecture: img.Architecture,
+		OS:           img.OS,
+		Config:       img.Config.Copy(),
+		RootFS: RootFS{
+			Type:    "layers",
+			DiffIDs: append([]string{}, img.RootFS.DiffIDs...),
+		},
+		History: append([]History{}, img.History...),
+	}
+
+	return child
+}
+
+// Copy 深拷贝运行配置
+func (c Config) Copy() Config {
+	c.Env = append([]string(nil), c.Env...)
+	c.Entrypoint = append([]string(nil), c.Entrypoint...)
+	c.Cmd = append([]string(nil), c.Cmd...)
+
+	if c.ExposedPorts != nil {
+		ports := make(map[string]struct{}, len(c.ExposedPorts))
+		for port := range c.ExposedPorts {
+			ports[port] = struct{}{}
+		}
+		c.ExposedPorts = ports
+	}
+	if c.Labels != nil {
+		labels := make(map[string]string, len(c.Labels))
+		for k, v := range c.Labels {
+			labels[k] = v
+		}
+		c.Labels = labels
+	}
+
+	return c
+}
+
+// Command 根据镜像的 Entrypoint 和 Cmd 计算容器命令，用户指定的参数会替换 Cmd
+func (img *Image) Command(args []string) []string {
+	cmd := img.Config.Cmd
+	if len(args) > 0 {
+		cmd = args
+	}
+
+	command := make([]string, 0, len(img.Config.Entrypoint)+len(cmd))
+	command = append(command, img.Config.Entrypoint...)
+	return append(command, cmd...)
+}
+
+// ShortID 去掉 sha256: 前缀后的前 12 位
+func ShortID(id string) string {
+	id = trimDigestPrefix(id)
+	if len(id) > 12 {
+		return id[:12]
+	}
+
+	return id
+}
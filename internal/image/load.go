@@ -0,0 +1,295 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"godocker/internal/archive"
+)
+
+const (
+	dockerManifestFile = "manifest.json"
+	ociIndexFile       = "index.json"
+	ociLayoutFile      = "oci-layout"
+
+	mediaTypeOCIIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIConfig   = "application/vnd.oci.image.config.v1+json"
+	mediaTypeOCILayer    = "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeDockerList  = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	annotationRefName        = "org.opencontainers.image.ref.name"
+	annotationContainerdName = "io.containerd.image.name"
+)
+
+// digestPattern 只接受 sha256 摘要，摘要会被拼接为 blobs 和 layerdb 中的路径
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// dockerManifest docker save 生成的 manifest.json 中的一项
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// Load 导入 docker save 或者 OCI image layout 格式的 tar 包，返回导入的镜像引用
+func (s *Store) Load(r io.Reader) ([]string, error) {
+	if err := os.MkdirAll(s.Root, 0700); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(s.Root, "load-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := archive.Untar(r, dir); err != nil {
+		return nil, fmt.Errorf("untar image archive error: %v", err)
+	}
+
+	if _, err := os.Stat(path.Join(dir, dockerManifestFile)); err == nil {
+		return s.loadDocker(dir)
+	}
+	if _, err := os.Stat(path.Join(dir, ociIndexFile)); err == nil {
+		return s.loadOCI(dir)
+	}
+
+	return nil, fmt.Errorf("neither %s nor %s found in archive", dockerManifestFile, ociIndexFile)
+}
+
+func (s *Store) loadDocker(dir string) ([]string, error) {
+	var manifests []dockerManifest
+	if err := readJSON(path.Join(dir, dockerManifestFile), &manifests); err != nil {
+		return nil, err
+	}
+
+	var loaded []string
+	for _, m := range manifests {
+		configPath, err := archivePath(dir, m.Config)
+		if err != nil {
+			return loaded, err
+		}
+		layers := make([]string, 0, len(m.Layers))
+		for _, layer := range m.Layers {
+			layerPath, err := archivePath(dir, layer)
+			if err != nil {
+				return loaded, err
+			}
+			layers = append(layers, layerPath)
+		}
+
+		id, err := s.loadImage(configPath, layers)
+		if err != nil {
+			return loaded, err
+		}
+
+		refs, err := s.tagLoaded(id, m.RepoTags)
+		if err != nil {
+			return loaded, err
+		}
+		loaded = append(loaded, refs...)
+	}
+
+	return loaded, nil
+}
+
+func (s *Store) loadOCI(dir string) ([]string, error) {
+	var index ociIndex
+	if err := readJSON(path.Join(dir, ociIndexFile), &index); err != nil {
+		return nil, err
+	}
+
+	var loaded []string
+	for _, desc := range index.Manifests {
+		manifest, err := resolveOCIManifest(dir, desc)
+		if err != nil {
+			return loaded, err
+		}
+
+		configPath, err := blobPath(dir, manifest.Config.Digest)
+		if err != nil {
+			return loaded, err
+		}
+		layers := make([]string, 0, len(manifest.Layers))
+		for _, layer := range manifest.Layers {
+			layerPath, err := blobPath(dir, layer.Digest)
+			if err != nil {
+				return loaded, err
+			}
+			layers = append(layers, layerPath)
+		}
+
+		id, err := s.loadImage(configPath, layers)
+		if err != nil {
+			return loaded, err
+		}
+
+		var tags []string
+		if ref := ociReference(desc.Annotations); ref != "" {
+			tags = append(tags, ref)
+		}
+		refs, err := s.tagLoaded(id, tags)
+		if err != nil {
+			return loaded, err
+		}
+		loaded = append(loaded, refs...)
+	}
+
+	return loaded, nil
+}
+
+// resolveOCIManifest 解析描述符指向的 manifest，多平台的 index 选择当前平台的 manifest
+func resolveOCIManifest(dir string, desc ociDescriptor) (*ociManifest, error) {
+	blob, err := blobPath(dir, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	switch desc.MediaType {
+	case mediaTypeOCIIndex, mediaTypeDockerList:
+		var index ociIndex
+		if err := readJSON(blob, &index); err != nil {
+			return nil, err
+		}
+		for _, m := range index.Manifests {
+			if m.Platform == nil || (m.Platform.OS == runtime.GOOS && m.Platform.Architecture == runtime.GOARCH) {
+				return resolveOCIManifest(dir, m)
+			}
+		}
+		return nil, fmt.Errorf("no manifest for %s/%s in %s", runtime.GOOS, runtime.GOARCH, desc.Digest)
+
+	default:
+		var manifest ociManifest
+		if err := readJSON(blob, &manifest); err != nil {
+			return nil, err
+		}
+		return &manifest, nil
+	}
+}
+
+// ociReference 从注解中获取镜像引用，ref.name 只有 tag 时无法得知镜像名，不打标签
+func ociReference(annotations map[string]string) string {
+	if ref := annotations[annotationContainerdName]; ref != "" {
+		return ref
+	}
+
+	ref := annotations[annotationRefName]
+	if strings.ContainsAny(ref, ":/") {
+		return ref
+	}
+
+	return ""
+}
+
+// loadImage 先校验所有镜像层的 diff id，全部一致后再导入镜像层并保存镜像配置
+func (s *Store) loadImage(configPath string, layers []string) (string, error) {
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return "", err
+	}
+
+	var img Image
+	if err := json.Unmarshal(content, &img); err != nil {
+		return "", fmt.Errorf("unmarshal image config error: %v", err)
+	}
+	if len(img.RootFS.DiffIDs) != len(layers) {
+		return "", fmt.Errorf("image config has %d layers but manifest has %d", len(img.RootFS.DiffIDs), len(layers))
+	}
+
+	for i, layerPath := range layers {
+		if !digestPattern.MatchString(img.RootFS.DiffIDs[i]) {
+			return "", fmt.Errorf("invalid diff id %q", img.RootFS.DiffIDs[i])
+		}
+		digest, err := layerDigest(layerPath)
+		if err != nil {
+			return "", err
+		}
+		if digest != img.RootFS.DiffIDs[i] {
+			return "", fmt.Errorf("layer %d digest mismatch, expected %s, got %s", i, img.RootFS.DiffIDs[i], digest)
+		}
+	}
+	for _, layerPath := range layers {
+		if _, err := s.ImportLayer(layerPath); err != nil {
+			return "", err
+		}
+	}
+
+	return s.CreateFromConfig(content)
+}
+
+func (s *Store) tagLoaded(id string, tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return []string{id}, nil
+	}
+
+	for _, tag := range tags {
+		if err := s.Tag(id, tag); err != nil {
+			return nil, err
+		}
+	}
+
+	return tags, nil
+}
+
+func blobPath(dir, digest string) (string, error) {
+	if !digestPattern.MatchString(digest) {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+
+	return archivePath(dir, path.Join("blobs", strings.Replace(digest, ":", "/", 1)))
+}
+
+// archivePath manifest 中的路径必须是解压目录中的相对路径，符号链接也不能指向目录之外
+func archivePath(dir, name string) (string, error) {
+	if name == "" || path.IsAbs(name) || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("invalid path %q in archive", name)
+	}
+
+	return archive.ResolveInScope(dir, name)
+}
+
+func readJSON(filename string, v interface{}) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("unmarshal %s error: %v", path.Base(filename), err)
+	}
+
+	return nil
+}
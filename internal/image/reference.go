@@ -0,0 +1,56 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultTag   = "latest"
+	digestPrefix = "sha256:"
+)
+
+var (
+	// [domain[:port]/]component[/component...]
+	nameRegexp = regexp.MustCompile(`^(?:[a-zA-Z0-9.-]+(?::[0-9]+)?/)?[a-z0-9]+(?:[._-]+[a-z0-9]+)*(?:/[a-z0-9]+(?:[._-]+[a-z0-9]+)*)*$`)
+	tagRegexp  = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	hexRegexp  = regexp.MustCompile(`^[a-f0-9]+$`)
+)
+
+// ParseReference 解析 name[:tag] 形式的镜像引用，tag 缺省为 latest
+func ParseReference(ref string) (string, string, error) {
+	name, tag := ref, defaultTag
+	// 冒号出现在最后一个 / 之后才是 tag，例如 localhost:5000/busybox
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, tag = ref[:i], ref[i+1:]
+	}
+
+	if !nameRegexp.MatchString(name) {
+		return "", "", fmt.Errorf("invalid image name: %s", ref)
+	}
+	if !tagRegexp.MatchString(tag) {
+		return "", "", fmt.Errorf("invalid image tag: %s", ref)
+	}
+
+	return name, tag, nil
+}
+
+// NormalizeReference 返回 name:tag 形式的镜像引用
+func NormalizeReference(ref string) (string, error) {
+	name, tag, err := ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+
+	return name + ":" + tag, nil
+}
+
+func trimDigestPrefix(id string) string {
+	return strings.TrimPrefix(id, digestPrefix)
+}
+
+// isDigest 判断引用是否为镜像 ID 或者镜像 ID 的前缀
+func isDigest(ref string) bool {
+	return hexRegexp.MatchString(trimDigestPrefix(ref))
+}
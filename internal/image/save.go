@@ -0,0 +1,262 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"godocker/internal/archive"
+)
+
+const (
+	FormatDocker = "docker"
+	FormatOCI    = "oci"
+)
+
+// exportedLayer 导出时重新打包的镜像层
+type exportedLayer struct {
+	digest string
+	size   int64
+	file   string
+}
+
+// Save 将镜像导出为 docker save（FormatDocker）或 OCI image layout（FormatOCI）格式的 tar 包。
+// 镜像层由解压后的目录重新打包，打包结果与原始 tar 不一致时会更新配置中的 diff_ids，此时镜像 ID 会变化。
+func (s *Store) Save(refs []string, format string, w io.Writer) error {
+	if format != FormatDocker && format != FormatOCI {
+		return fmt.Errorf("unsupported image archive format: %s", format)
+	}
+
+	if err := os.MkdirAll(s.Root, 0700); err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir(s.Root, "save-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	layers := make(map[string]*exportedLayer)
+	var dockerManifests []dockerManifest
+	index := ociIndex{SchemaVersion: 2, MediaType: mediaTypeOCIIndex}
+
+	for _, ref := range refs {
+		img, err := s.Get(ref)
+		if err != nil {
+			return err
+		}
+		tag, err := s.savedTag(ref, img.ID)
+		if err != nil {
+			return err
+		}
+
+		var diffIDs []string
+		var exported []*exportedLayer
+		for _, digest := range img.RootFS.DiffIDs {
+			layer, ok := layers[digest]
+			if !ok {
+				if layer, err = s.exportLayer(digest, dir, format); err != nil {
+					return err
+				}
+				layers[digest] = layer
+			}
+			diffIDs = append(diffIDs, layer.digest)
+			exported = append(exported, layer)
+		}
+
+		content, err := s.RawConfig(img.ID)
+		if err != nil {
+			return err
+		}
+		if strings.Join(diffIDs, ",") != strings.Join(img.RootFS.DiffIDs, ",") {
+			if content, err = replaceDiffIDs(content, diffIDs); err != nil {
+				return err
+			}
+		}
+		configDigest, err := writeBlob(dir, format, content)
+		if err != nil {
+			return err
+		}
+
+		switch format {
+		case FormatDocker:
+			m := dockerManifest{Config: trimDigestPrefix(configDigest) + ".json"}
+			if tag != "" {
+				m.RepoTags = []string{tag}
+			}
+			for _, layer := range exported {
+				m.Layers = append(m.Layers, layer.file)
+			}
+			dockerManifests = append(dockerManifests, m)
+
+		case FormatOCI:
+			desc, err := writeOCIManifest(dir, configDigest, int64(len(content)), exported)
+			if err != nil {
+				return err
+			}
+			if tag != "" {
+				name, tagName, _ := ParseReference(tag)
+				desc.Annotations = map[string]string{
+					annotationContainerdName: name + ":" + tagName,
+					annotationRefName:        tagName,
+				}
+			}
+			index.Manifests = append(index.Manifests, *desc)
+		}
+	}
+
+	switch format {
+	case FormatDocker:
+		if err := writeJSON(path.Join(dir, dockerManifestFile), dockerManifests); err != nil {
+			return err
+		}
+	case FormatOCI:
+		if err := writeJSON(path.Join(dir, ociLayoutFile), map[string]string{"imageLayoutVersion": "1.0.0"}); err != nil {
+			return err
+		}
+		if err := writeJSON(path.Join(dir, ociIndexFile), index); err != nil {
+			return err
+		}
+	}
+
+	return archive.Tar(dir, w)
+}
+
+// savedTag 通过 name:tag 导出时在归档中记录该 tag，通过镜像 ID 导出时不记录
+func (s *Store) savedTag(ref, id string) (string, error) {
+	name, err := NormalizeReference(ref)
+	if err != nil {
+		return "", nil
+	}
+
+	repositories, err := s.loadRepositories()
+	if err != nil {
+		return "", err
+	}
+	if repositories[name] == id {
+		return name, nil
+	}
+
+	return "", nil
+}
+
+// exportLayer 将镜像层目录打包到 dir 中，docker 格式为 <digest>/layer.tar，OCI 格式为 blobs/sha256/<digest>
+func (s *Store) exportLayer(digest, dir, format string) (*exportedLayer, error) {
+	tmp, err := ioutil.TempFile(dir, "layer-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	if err := archive.TarLayer(s.LayerDir(digest), io.MultiWriter(tmp, h)); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("tar layer %s error: %v", digest, err)
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	hexDigest := hex.EncodeToString(h.Sum(nil))
+	layer := &exportedLayer{
+		digest: digestPrefix + hexDigest,
+		size:   info.Size(),
+		file:   path.Join(hexDigest, "layer.tar"),
+	}
+	if format == FormatOCI {
+		layer.file = path.Join("blobs", "sha256", hexDigest)
+	}
+
+	target := path.Join(dir, layer.file)
+	if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+		return nil, err
+	}
+
+	return layer, os.Rename(tmp.Name(), target)
+}
+
+// writeBlob 保存镜像配置，docker 格式为 <digest>.json，OCI 格式为 blobs/sha256/<digest>
+func writeBlob(dir, format string, content []byte) (string, error) {
+	sum := sha256.Sum256(content)
+	hexDigest := hex.EncodeToString(sum[:])
+
+	target := path.Join(dir, hexDigest+".json")
+	if format == FormatOCI {
+		target = path.Join(dir, "blobs", "sha256", hexDigest)
+	}
+	if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+		return "", err
+	}
+
+	return digestPrefix + hexDigest, ioutil.WriteFile(target, content, 0644)
+}
+
+func writeOCIManifest(dir, configDigest string, configSize int64, layers []*exportedLayer) (*ociDescriptor, error) {
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Config: ociDescriptor{
+			MediaType: mediaTypeOCIConfig,
+			Digest:    configDigest,
+			Size:      configSize,
+		},
+	}
+	for _, layer := range layers {
+		manifest.Layers = append(manifest.Layers, ociDescriptor{
+			MediaType: mediaTypeOCILayer,
+			Digest:    layer.digest,
+			Size:      layer.size,
+		})
+	}
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := writeBlob(dir, FormatOCI, content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ociDescriptor{
+		MediaType: mediaTypeOCIManifest,
+		Digest:    digest,
+		Size:      int64(len(content)),
+	}, nil
+}
+
+// replaceDiffIDs 替换镜像配置中的 rootfs.diff_ids，保留其余字段
+func replaceDiffIDs(content []byte, diffIDs []string) ([]byte, error) {
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+
+	rootfs, err := json.Marshal(RootFS{Type: "layers", DiffIDs: diffIDs})
+	if err != nil {
+		return nil, err
+	}
+	config["rootfs"] = rootfs
+
+	return json.Marshal(config)
+}
+
+func writeJSON(filename string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, content, 0644)
+}
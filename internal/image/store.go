@@ -0,0 +1,584 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"godocker/internal/archive"
+	"godocker/internal/config"
+	"godocker/pkg"
+)
+
+const repositoriesFile = "repositories.json"
+
+// Store 内容寻址的镜像存储
+// <root>/repositories.json        name:tag -> 镜像 ID
+// <root>/imagedb/<hex>.json       镜像配置，<hex> 为配置内容的 sha256
+// <root>/layerdb/<hex>/layer.json 镜像层元数据
+// <root>/layerdb/<hex>/diff       解压后的镜像层，多个容器共享
+type Store struct {
+	Root string
+}
+
+// NewStore 返回当前配置下的镜像存储
+func NewStore() *Store {
+	return &Store{Root: path.Join(config.Get().Root, "image")}
+}
+
+func (s *Store) imagePath(id string) string {
+	return path.Join(s.Root, "imagedb", trimDigestPrefix(id)+".json")
+}
+
+func (s *Store) layerPath(digest string) string {
+	return path.Join(s.Root, "layerdb", trimDigestPrefix(digest))
+}
+
+// LayerDir 返回镜像层解压后的目录
+func (s *Store) LayerDir(digest string) string {
+	return path.Join(s.layerPath(digest), "diff")
+}
+
+// LayerDirs 返回镜像所有层的目录，上层在前，可直接作为 overlay 的 lowerdir
+func (s *Store) LayerDirs(img *Image) []string {
+	dirs := make([]string, 0, len(img.RootFS.DiffIDs))
+	for i := len(img.RootFS.DiffIDs) - 1; i >= 0; i-- {
+		dirs = append(dirs, s.LayerDir(img.RootFS.DiffIDs[i]))
+	}
+
+	return dirs
+}
+
+func (s *Store) loadRepositories() (map[string]string, error) {
+	repositories := make(map[string]string)
+	content, err := ioutil.ReadFile(path.Join(s.Root, repositoriesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return repositories, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, &repositories); err != nil {
+		return nil, fmt.Errorf("unmarshal %s error: %v", repositoriesFile, err)
+	}
+
+	return repositories, nil
+}
+
+func (s *Store) dumpRepositories(repositories map[string]string) error {
+	content, err := json.Marshal(repositories)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path.Join(s.Root, repositoriesFile), content)
+}
+
+// Get 根据 name:tag、镜像 ID 或者镜像 ID 前缀查找镜像
+func (s *Store) Get(ref string) (*Image, error) {
+	id, err := s.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.load(id)
+}
+
+func (s *Store) resolve(ref string) (string, error) {
+	repositories, err := s.loadRepositories()
+	if err != nil {
+		return "", err
+	}
+
+	if name, err := NormalizeReference(ref); err == nil {
+		if id, ok := repositories[name]; ok {
+			return id, nil
+		}
+	}
+
+	if isDigest(ref) {
+		ids, err := s.ids()
+		if err != nil {
+			return "", err
+		}
+
+		var matches []string
+		for _, id := range ids {
+			if strings.HasPrefix(trimDigestPrefix(id), trimDigestPrefix(ref)) {
+				matches = append(matches, id)
+			}
+		}
+		if len(matches) == 1 {
+			return matches[0], nil
+		}
+		if len(matches) > 1 {
+			return "", fmt.Errorf("ambiguous image id prefix: %s", ref)
+		}
+	}
+
+	return "", fmt.Errorf("no such image: %s", ref)
+}
+
+func (s *Store) ids() ([]string, error) {
+	files, err := ioutil.ReadDir(path.Join(s.Root, "imagedb"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ids []string
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".json") {
+			ids = append(ids, digestPrefix+strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+
+	return ids, nil
+}
+
+// RawConfig 返回镜像配置的原始内容，其 sha256 即镜像 ID
+func (s *Store) RawConfig(id string) ([]byte, error) {
+	content, err := ioutil.ReadFile(s.imagePath(id))
+	if err != nil {
+		return nil, fmt.Errorf("read image %s error: %v", id, err)
+	}
+
+	return content, nil
+}
+
+func (s *Store) load(id string) (*Image, error) {
+	content, err := s.RawConfig(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var img Image
+	if err := json.Unmarshal(content, &img); err != nil {
+		return nil, fmt.Errorf("unmarshal image %s error: %v", id, err)
+	}
+	img.ID = id
+
+	return &img, nil
+}
+
+// List 返回所有镜像，按创建时间倒序排列
+func (s *Store) List() ([]*Image, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]*Image, 0, len(ids))
+	for _, id := range ids {
+		img, err := s.load(id)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Created.After(images[j].Created)
+	})
+
+	return images, nil
+}
+
+// Tags 返回指向该镜像的所有 name:tag
+func (s *Store) Tags(id string) ([]string, error) {
+	repositories, err := s.loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for ref, imageID := range repositories {
+		if imageID == id {
+			tags = append(tags, ref)
+		}
+	}
+	sort.Strings(tags)
+
+	return tags, nil
+}
+
+// Size 返回镜像所有层的大小之和
+func (s *Store) Size(img *Image) int64 {
+	var size int64
+	for _, digest := range img.RootFS.DiffIDs {
+		if layer, err := s.Layer(digest); err == nil {
+			size += layer.Size
+		}
+	}
+
+	return size
+}
+
+// Create 保存镜像配置，返回镜像 ID
+func (s *Store) Create(img *Image) (string, error) {
+	content, err := json.Marshal(img)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := s.CreateFromConfig(content)
+	if err != nil {
+		return "", err
+	}
+	img.ID = id
+
+	return id, nil
+}
+
+// CreateFromConfig 按原样保存镜像配置，导入的镜像因此保持原有的镜像 ID
+func (s *Store) CreateFromConfig(content []byte) (string, error) {
+	var img Image
+	if err := json.Unmarshal(content, &img); err != nil {
+		return "", fmt.Errorf("unmarshal image config error: %v", err)
+	}
+	for _, digest := range img.RootFS.DiffIDs {
+		if _, err := s.Layer(digest); err != nil {
+			return "", err
+		}
+	}
+
+	sum := sha256.Sum256(content)
+	id := digestPrefix + hex.EncodeToString(sum[:])
+	if err := writeFileAtomic(s.imagePath(id), content); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Tag 将 name:tag 指向镜像 id，已存在的同名引用会被覆盖
+func (s *Store) Tag(id, ref string) error {
+	name, err := NormalizeReference(ref)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(s.imagePath(id)); err != nil {
+		return fmt.Errorf("no such image: %s", id)
+	}
+
+	repositories, err := s.loadRepositories()
+	if err != nil {
+		return err
+	}
+	repositories[name] = id
+
+	return s.dumpRepositories(repositories)
+}
+
+// Remove 删除镜像引用。引用是 name:tag 且镜像还有其他 tag 时只删除该 tag，
+// 否则删除镜像以及不再被其他镜像使用的层。inUse 返回 true 时拒绝删除镜像。
+func (s *Store) Remove(ref string, inUse func(id string) bool) error {
+	id, err := s.resolve(ref)
+	if err != nil {
+		return err
+	}
+
+	repositories, err := s.loadRepositories()
+	if err != nil {
+		return err
+	}
+
+	if name, err := NormalizeReference(ref); err == nil && repositories[name] == id {
+		tags, err := s.Tags(id)
+		if err != nil {
+			return err
+		}
+		if len(tags) > 1 {
+			delete(repositories, name)
+			return s.dumpRepositories(repositories)
+		}
+	}
+
+	if inUse != nil && inUse(id) {
+		return fmt.Errorf("image %s is being used by containers", ref)
+	}
+
+	if children, err := s.HasChildren(id); err != nil {
+		return err
+	} else if children {
+		return fmt.Errorf("image %s has dependent child images", ref)
+	}
+
+	img, err := s.load(id)
+	if err != nil {
+		return err
+	}
+
+	for name, imageID := range repositories {
+		if imageID == id {
+			delete(repositories, name)
+		}
+	}
+	if err := s.dumpRepositories(repositories); err != nil {
+		return err
+	}
+	if err := os.Remove(s.imagePath(id)); err != nil {
+		return err
+	}
+	if err := s.removeUnusedLayers(img.RootFS.DiffIDs); err != nil {
+		return err
+	}
+
+	return s.removeDanglingParent(img.Parent, inUse)
+}
+
+// removeDanglingParent 删除没有 tag、没有其他子镜像的父镜像，例如 build 产生的中间镜像
+func (s *Store) removeDanglingParent(id string, inUse func(id string) bool) error {
+	if id == "" {
+		return nil
+	}
+	if _, err := os.Stat(s.imagePath(id)); err != nil {
+		return nil
+	}
+	if tags, err := s.Tags(id); err != nil || len(tags) > 0 {
+		return err
+	}
+	if inUse != nil && inUse(id) {
+		return nil
+	}
+	if children, err := s.HasChildren(id); err != nil || children {
+		return err
+	}
+
+	return s.Remove(id, inUse)
+}
+
+// HasChildren 判断镜像是否是其他镜像的父镜像
+func (s *Store) HasChildren(id string) (bool, error) {
+	images, err := s.List()
+	if err != nil {
+		return false, err
+	}
+	for _, img := range images {
+		if img.Parent == id {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// removeUnusedLayers 删除 digests 中不再被任何镜像引用的层
+func (s *Store) removeUnusedLayers(digests []string) error {
+	images, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	used := make(map[string]bool)
+	for _, img := range images {
+		for _, digest := range img.RootFS.DiffIDs {
+			used[digest] = true
+		}
+	}
+
+	for _, digest := range digests {
+		if used[digest] {
+			continue
+		}
+		if err := os.RemoveAll(s.layerPath(digest)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Layer 读取镜像层元数据
+func (s *Store) Layer(digest string) (*Layer, error) {
+	content, err := ioutil.ReadFile(path.Join(s.layerPath(digest), "layer.json"))
+	if err != nil {
+		return nil, fmt.Errorf("no such layer: %s", digest)
+	}
+
+	var layer Layer
+	if err := json.Unmarshal(content, &layer); err != nil {
+		return nil, err
+	}
+
+	return &layer, nil
+}
+
+// ImportLayer 将 tar 格式（可以是 gzip 压缩的）的镜像层解压到存储中，同一个 digest 的层只会解压一次
+func (s *Store) ImportLayer(tarPath string) (*Layer, error) {
+	digest, err := layerDigest(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	if layer, err := s.Layer(digest); err == nil {
+		return layer, nil
+	}
+
+	layerDir := s.layerPath(digest)
+	tmpDir := layerDir + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path.Join(tmpDir, "diff"), 0755); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := applyLayer(tarPath, path.Join(tmpDir, "diff")); err != nil {
+		return nil, fmt.Errorf("apply layer %s error: %v", tarPath, err)
+	}
+
+	size, err := pkg.DirSize(path.Join(tmpDir, "diff"))
+	if err != nil {
+		return nil, err
+	}
+	layer := &Layer{
+		Digest:  digest,
+		Size:    size,
+		Created: time.Now(),
+	}
+	content, err := json.Marshal(layer)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path.Join(tmpDir, "layer.json"), content, 0600); err != nil {
+		return nil, err
+	}
+
+	if err := os.RemoveAll(layerDir); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpDir, layerDir); err != nil {
+		return nil, err
+	}
+
+	return layer, nil
+}
+
+// CreateLayer 将 write 写出的 tar 导入为镜像层
+func (s *Store) CreateLayer(write func(w io.Writer) error) (*Layer, error) {
+	if err := os.MkdirAll(s.Root, 0700); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(s.Root, "layer-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	return s.ImportLayer(tmp.Name())
+}
+
+// ImportRootfs 将一个完整 rootfs 的 tar 包导入为单层镜像，并打上 ref 标签
+func (s *Store) ImportRootfs(tarPath, ref string) (*Image, error) {
+	layer, err := s.ImportLayer(tarPath)
+	if err != nil {
+		return nil, err
+	}
+
+	created := time.Now().UTC()
+	img := &Image{
+		Created:      created,
+		Architecture: runtime.GOARCH,
+		OS:           runtime.GOOS,
+		RootFS: RootFS{
+			Type:    "layers",
+			DiffIDs: []string{layer.Digest},
+		},
+		History: []History{{
+			Created:   created,
+			CreatedBy: "import " + path.Base(tarPath),
+		}},
+	}
+	id, err := s.Create(img)
+	if err != nil {
+		return nil, err
+	}
+
+	return img, s.Tag(id, ref)
+}
+
+// Resolve 查找镜像，找不到时尝试导入数据目录下同名的 rootfs tar 包（<root>/<name>.tar）
+func (s *Store) Resolve(ref string) (*Image, error) {
+	img, err := s.Get(ref)
+	if err == nil {
+		return img, nil
+	}
+
+	name, _, parseErr := ParseReference(ref)
+	if parseErr != nil {
+		return nil, err
+	}
+	tarPath := path.Join(config.Get().Root, name+".tar")
+	if exist, _ := pkg.PathExists(tarPath); !exist {
+		return nil, err
+	}
+
+	return s.ImportRootfs(tarPath, ref)
+}
+
+// layerDigest 计算镜像层解压后 tar 内容的 sha256，即 diff id
+func layerDigest(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	rc, err := archive.DecompressStream(f)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return "", err
+	}
+
+	return digestPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func applyLayer(tarPath, dest string) error {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return archive.ApplyLayer(f, dest)
+}
+
+// writeFileAtomic 先写临时文件再重命名，避免进程中断时留下不完整的文件
+func writeFileAtomic(filename string, content []byte) error {
+	if err := os.MkdirAll(path.Dir(filename), 0700); err != nil {
+		return err
+	}
+
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filename)
+}
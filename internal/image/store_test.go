@@ -0,0 +1,260 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"godocker/internal/archive"
+)
+
+func TestParseReference(t *testing.T) {
+	cases := []struct {
+		ref, name, tag string
+	}{
+		{"busybox", "busybox", "latest"},
+		{"busybox:1.35", "busybox", "1.35"},
+		{"localhost:5000/busybox", "localhost:5000/busybox", "latest"},
+		{"library/busybox:v1", "library/busybox", "v1"},
+	}
+	for _, c := range cases {
+		name, tag, err := ParseReference(c.ref)
+		if err != nil || name != c.name || tag != c.tag {
+			t.Errorf("parse %s: %s, %s, %v", c.ref, name, tag, err)
+		}
+	}
+
+	if _, _, err := ParseReference("BusyBox"); err == nil {
+		t.Errorf("parse BusyBox should fail")
+	}
+}
+
+func TestStore(t *testing.T) {
+	store := &Store{Root: t.TempDir()}
+	img, err := store.ImportRootfs("../../examples/data/busybox.tar", "busybox")
+	if err != nil {
+		t.Fatalf("import error %v", err)
+	}
+	t.Logf("image %s, size %d", img.ID, store.Size(img))
+
+	// 相同内容的层只保存一份
+	other, err := store.ImportRootfs("../../examples/data/busybox.tar", "busybox:other")
+	if err != nil {
+		t.Fatalf("import error %v", err)
+	}
+	if len(store.LayerDirs(other)) != 1 || store.LayerDirs(other)[0] != store.LayerDirs(img)[0] {
+		t.Errorf("layer should be shared: %v, %v", store.LayerDirs(other), store.LayerDirs(img))
+	}
+
+	for _, ref := range []string{"busybox", "busybox:latest", img.ID, ShortID(img.ID)} {
+		if got, err := store.Get(ref); err != nil || got.ID != img.ID {
+			t.Errorf("get %s: %v", ref, err)
+		}
+	}
+
+	inUse := func(id string) bool { return true }
+	if err := store.Remove("busybox", inUse); err == nil {
+		t.Errorf("remove image in use should fail")
+	}
+	if err := store.Remove("busybox", nil); err != nil {
+		t.Fatalf("remove error %v", err)
+	}
+	if _, err := store.Get("busybox"); err == nil {
+		t.Errorf("busybox should be removed")
+	}
+	if _, err := store.Layer(img.RootFS.DiffIDs[0]); err != nil {
+		t.Errorf("layer used by busybox:other should be kept: %v", err)
+	}
+}
+
+// buildLayer 生成一个 tar 格式的镜像层，返回内容和 diff id
+func buildLayer(t *testing.T, files map[string]string) ([]byte, string) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(files[name]))}
+		if strings.HasSuffix(name, "/") {
+			hdr = &tar.Header{Name: name, Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	return buf.Bytes(), digestPrefix + hex.EncodeToString(sum[:])
+}
+
+func TestLoadSave(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("whiteout need root")
+	}
+
+	base, baseDigest := buildLayer(t, map[string]string{
+		"etc/": "", "etc/a": "a", "opt/": "", "opt/x": "x",
+	})
+	top, topDigest := buildLayer(t, map[string]string{
+		"etc/": "", "etc/.wh.a": "", "opt/": "", "opt/.wh..wh..opq": "", "opt/y": "y",
+	})
+	config, _ := json.Marshal(&Image{
+		Config: Config{Cmd: []string{"sh"}},
+		RootFS: RootFS{Type: "layers", DiffIDs: []string{baseDigest, topDigest}},
+	})
+	manifest, _ := json.Marshal([]dockerManifest{{
+		Config:   "config.json",
+		RepoTags: []string{"test:v1"},
+		Layers:   []string{"base/layer.tar", "top/layer.tar"},
+	}})
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range map[string][]byte{
+		"manifest.json":  manifest,
+		"config.json":    config,
+		"base/layer.tar": base,
+		"top/layer.tar":  top,
+	} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &Store{Root: t.TempDir()}
+	refs, err := store.Load(&buf)
+	if err != nil || len(refs) != 1 || refs[0] != "test:v1" {
+		t.Fatalf("load: %v, %v", refs, err)
+	}
+	img, err := store.Get("test:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(config)
+	if img.ID != digestPrefix+hex.EncodeToString(sum[:]) {
+		t.Errorf("image id should be the digest of config: %s", img.ID)
+	}
+
+	info, err := os.Lstat(path.Join(store.LayerDir(topDigest), "etc", "a"))
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		t.Errorf("etc/a should be a whiteout: %v", err)
+	}
+	if !archive.IsOpaque(path.Join(store.LayerDir(topDigest), "opt")) {
+		t.Errorf("opt should be opaque")
+	}
+
+	for _, format := range []string{FormatDocker, FormatOCI} {
+		var saved bytes.Buffer
+		if err := store.Save([]string{"test:v1"}, format, &saved); err != nil {
+			t.Fatalf("save %s: %v", format, err)
+		}
+
+		other := &Store{Root: t.TempDir()}
+		refs, err := other.Load(&saved)
+		if err != nil || len(refs) != 1 || refs[0] != "test:v1" {
+			t.Fatalf("load %s: %v, %v", format, refs, err)
+		}
+		loaded, err := other.Get("test:v1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(loaded.RootFS.DiffIDs) != 2 || loaded.Config.Cmd[0] != "sh" {
+			t.Errorf("loaded %s image: %+v", format, loaded)
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	layer, layerDigest := buildLayer(t, map[string]string{"a": "a"})
+	config, _ := json.Marshal(&Image{RootFS: RootFS{Type: "layers", DiffIDs: []string{layerDigest}}})
+	wrongConfig, _ := json.Marshal(&Image{RootFS: RootFS{Type: "layers", DiffIDs: []string{digestPrefix + strings.Repeat("0", 64)}}})
+
+	dockerArchive := func(m dockerManifest, files map[string]string) []byte {
+		manifest, _ := json.Marshal([]dockerManifest{m})
+		files["manifest.json"] = string(manifest)
+		content, _ := buildLayer(t, files)
+		return content
+	}
+	ociArchive := func(digest string) []byte {
+		index, _ := json.Marshal(&ociIndex{SchemaVersion: 2, Manifests: []ociDescriptor{{MediaType: mediaTypeOCIManifest, Digest: digest}}})
+		content, _ := buildLayer(t, map[string]string{"index.json": string(index), "oci-layout": "{}"})
+		return content
+	}
+
+	cases := map[string][]byte{
+		"config outside archive": dockerArchive(dockerManifest{Config: "../config.json", Layers: []string{"layer.tar"}},
+			map[string]string{"layer.tar": string(layer)}),
+		"absolute layer": dockerArchive(dockerManifest{Config: "config.json", Layers: []string{"/layer.tar"}},
+			map[string]string{"config.json": string(config), "layer.tar": string(layer)}),
+		"unclean layer": dockerArchive(dockerManifest{Config: "config.json", Layers: []string{"x/../layer.tar"}},
+			map[string]string{"config.json": string(config), "layer.tar": string(layer)}),
+		"diff id mismatch": dockerArchive(dockerManifest{Config: "config.json", Layers: []string{"layer.tar"}},
+			map[string]string{"config.json": string(wrongConfig), "layer.tar": string(layer)}),
+		"invalid digest":  ociArchive("sha256:../../index.json"),
+		"other algorithm": ociArchive("sha512:" + strings.Repeat("0", 128)),
+	}
+	for name, content := range cases {
+		store := &Store{Root: t.TempDir()}
+		if _, err := store.Load(bytes.NewReader(content)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+		// 校验失败时不会导入任何镜像层
+		if layers, _ := os.ReadDir(path.Join(store.Root, "layerdb")); len(layers) != 0 {
+			t.Errorf("%s: layers imported", name)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	store := &Store{Root: t.TempDir()}
+	base, err := store.ImportRootfs("../../examples/data/busybox.tar", "busybox")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := base.Child()
+	child.Config.SetEnv("FOO=bar")
+	child.History = append(child.History, History{Created: child.Created, CreatedBy: "ENV FOO=bar", EmptyLayer: true})
+	if _, err := store.Create(child); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Tag(child.ID, "busybox:env"); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := store.History("busybox:env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("history items: %+v", items)
+	}
+	if items[0].ImageID != child.ID || items[0].Layer != "" || items[0].CreatedBy != "ENV FOO=bar" {
+		t.Errorf("top history item: %+v", items[0])
+	}
+	if items[1].ImageID != base.ID || items[1].Layer != base.RootFS.DiffIDs[0] || items[1].Size == 0 {
+		t.Errorf("base history item: %+v", items[1])
+	}
+}
@@ -108,8 +108,25 @@ func (bnd *BridgeNetworkDriver) Connect(network *Network, endpoint *Endpoint) er
 	return nil
 }
 
-// Disconnect
+// Disconnect 删除 veth，容器的网络空间销毁时 veth 已经随之删除
 func (bnd *BridgeNetworkDriver) Disconnect(network *Network, endpoint *Endpoint) error {
+	return deleteLink(endpoint.Device.Name)
+}
+
+// deleteLink 删除网络设备，设备不存在时直接返回
+func deleteLink(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("error delete link %s: %v", name, err)
+	}
+
 	return nil
 }
 
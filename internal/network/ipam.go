@@ -2,28 +2,35 @@ package network
 
 import (
 	"encoding/json"
+	"fmt"
 	"net"
 	"os"
 	"path"
 	"strings"
 
+	"godocker/internal/config"
+
 	"github.com/sirupsen/logrus"
 )
 
-const ipamDefaultAllocatorPath = "/var/run/godocker/network/ipam/subnet.json"
-
-var IPAllocator = &IPAM{
-	SubnetAllocatorPath: ipamDefaultAllocatorPath,
-}
+var IPAllocator = &IPAM{}
 
 type IPAM struct {
-	SubnetAllocatorPath string
+	SubnetAllocatorPath string // 为空时使用当前配置中的 IPAM 路径
 	Subnets             *map[string]string
 }
 
+func (ipam *IPAM) allocatorPath() string {
+	if ipam.SubnetAllocatorPath != "" {
+		return ipam.SubnetAllocatorPath
+	}
+
+	return config.Get().IPAMPath()
+}
+
 // 加载网段地址分配信息
 func (ipam *IPAM) load() error {
-	if _, err := os.Stat(ipam.SubnetAllocatorPath); err != nil {
+	if _, err := os.Stat(ipam.allocatorPath()); err != nil {
 		if os.IsNotExist(err) {
 			return nil
 		} else {
@@ -31,7 +38,7 @@ func (ipam *IPAM) load() error {
 		}
 	}
 
-	subnetConfigFile, err := os.Open(ipam.SubnetAllocatorPath)
+	subnetConfigFile, err := os.Open(ipam.allocatorPath())
 	if err != nil {
 		return err
 	}
@@ -53,7 +60,7 @@ func (ipam *IPAM) load() error {
 }
 
 func (ipam *IPAM) dump() error {
-	ipamCOnfigFileDir, _ := path.Split(ipam.SubnetAllocatorPath)
+	ipamCOnfigFileDir, _ := path.Split(ipam.allocatorPath())
 	if _, err := os.Stat(ipamCOnfigFileDir); err != nil {
 		if os.IsNotExist(err) {
 			os.MkdirAll(ipamCOnfigFileDir, 0644)
@@ -62,7 +69,7 @@ func (ipam *IPAM) dump() error {
 		}
 	}
 
-	subnetConfigFile, err := os.OpenFile(ipam.SubnetAllocatorPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
+	subnetConfigFile, err := os.OpenFile(ipam.allocatorPath(), os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		return err
 	}
@@ -133,7 +140,8 @@ func (ipam *IPAM) Release(subnet *net.IPNet, ipAddr *net.IP) error {
 
 	// 计算 IP 地址在网段位图数组中的索引位置
 	c := 0
-	releaseIP := ipAddr.To4()
+	releaseIP := make(net.IP, net.IPv4len)
+	copy(releaseIP, ipAddr.To4())
 	releaseIP[3] -= 1
 
 	for t := uint(4); t > 0; t -= 1 {
@@ -143,6 +151,9 @@ func (ipam *IPAM) Release(subnet *net.IPNet, ipAddr *net.IP) error {
 	}
 
 	ipAlloc := []byte((*ipam.Subnets)[subnet.String()])
+	if c < 0 || c >= len(ipAlloc) {
+		return fmt.Errorf("ip %s is not allocated in subnet %s", ipAddr, subnet)
+	}
 	ipAlloc[c] = '0'
 	(*ipam.Subnets)[subnet.String()] = string(ipAlloc)
 
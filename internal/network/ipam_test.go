@@ -2,9 +2,24 @@ package network
 
 import (
 	"net"
+	"os"
 	"testing"
+
+	"godocker/internal/config"
 )
 
+func TestMain(m *testing.M) {
+	execRoot, err := os.MkdirTemp("", "godocker-ipam")
+	if err != nil {
+		panic(err)
+	}
+	config.Set(&config.Config{ExecRoot: execRoot})
+
+	code := m.Run()
+	_ = os.RemoveAll(execRoot)
+	os.Exit(code)
+}
+
 func Test_Allocate(t *testing.T) {
 	_, ipnet, _ := net.ParseCIDR("192.168.0.0/24")
 	ip, _ := IPAllocator.Allocate(ipnet)
@@ -12,6 +12,7 @@ import (
 	"strings"
 	"text/tabwriter"
 
+	"godocker/internal/config"
 	"godocker/internal/container"
 
 	"github.com/sirupsen/logrus"
@@ -20,9 +21,8 @@ import (
 )
 
 var (
-	defaultNetworkPath = "/var/run/godocker/network/network/"
-	drivers            = make(map[string]NetworkDriver)
-	networks           = make(map[string]*Network)
+	drivers  = make(map[string]NetworkDriver)
+	networks = make(map[string]*Network)
 )
 
 type Network struct {
@@ -115,15 +115,13 @@ func enterContainerNetns(erLink *netlink.Link, containerInfo *container.Info) fu
 
 func configPortMapping(ep *Endpoint, containerInfo *container.Info) error {
 	for _, pm := range ep.PortMapping {
-		portMapping := strings.Split(pm, ":")
-		if len(portMapping) != 2 {
+		rule, err := portMappingRule(pm, ep.IPAddress)
+		if err != nil {
 			logrus.Errorf("port mapping format error, %v", pm)
 			continue
 		}
 
-		iptablesCmd := fmt.Sprintf("-t nat -A PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
-			portMapping[0], ep.IPAddress.String(), portMapping[1])
-		cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
+		cmd := exec.Command("iptables", append([]string{"-t", "nat", "-A"}, rule...)...)
 		output, err := cmd.Output()
 		if err != nil {
 			logrus.Errorf("iptables output, %v", output)
@@ -134,6 +132,36 @@ func configPortMapping(ep *Endpoint, containerInfo *container.Info) error {
 	return nil
 }
 
+// removePortMapping 删除端口映射的 DNAT 规则，规则不存在时跳过
+func removePortMapping(portMappings []string, ip net.IP) {
+	for _, pm := range portMappings {
+		rule, err := portMappingRule(pm, ip)
+		if err != nil {
+			continue
+		}
+
+		// iptables -C 检查规则是否存在
+		if err := exec.Command("iptables", append([]string{"-t", "nat", "-C"}, rule...)...).Run(); err != nil {
+			continue
+		}
+		if output, err := exec.Command("iptables", append([]string{"-t", "nat", "-D"}, rule...)...).CombinedOutput(); err != nil {
+			logrus.Errorf("iptables delete %v error: %v, %s", rule, err, output)
+		}
+	}
+}
+
+// portMappingRule 宿主机端口:容器端口 对应的 DNAT 规则
+// PREROUTING -p tcp -m tcp --dport <host port> -j DNAT --to-destination <ip>:<container port>
+func portMappingRule(pm string, ip net.IP) ([]string, error) {
+	portMapping := strings.Split(pm, ":")
+	if len(portMapping) != 2 {
+		return nil, fmt.Errorf("port mapping format error, %v", pm)
+	}
+
+	return []string{"PREROUTING", "-p", "tcp", "-m", "tcp", "--dport", portMapping[0],
+		"-j", "DNAT", "--to-destination", fmt.Sprintf("%s:%s", ip.String(), portMapping[1])}, nil
+}
+
 func (nw *Network) dump(dumpPath string) error {
 	if _, err := os.Stat(dumpPath); err != nil {
 		if os.IsNotExist(err) {
@@ -217,7 +245,7 @@ func CreateNetwork(driver, subnet, name string) error {
 		return err
 	}
 
-	return nw.dump(defaultNetworkPath)
+	return nw.dump(config.Get().NetworkPath())
 }
 
 func ConnectNetwork(networkName string, containerInfo *container.Info) error {
@@ -237,12 +265,20 @@ func ConnectNetwork(networkName string, containerInfo *container.Info) error {
 		ID:          fmt.Sprintf("%s-%s", containerInfo.ID, networkName),
 		IPAddress:   ip,
 		Network:     network,
-		PortMapping: containerInfo.PortMapping,
+		PortMapping: containerInfo.Options().PortMapping,
 	}
 
 	if err := drivers[network.Driver].Connect(network, ep); err != nil {
+		_ = IPAllocator.Release(network.IPRange, &ip)
 		return err
 	}
+	// 记录分配的资源，后续步骤失败时同样可以通过 DisconnectNetwork 释放
+	containerInfo.Networks = append(containerInfo.Networks, container.Endpoint{
+		Network:     networkName,
+		Device:      ep.Device.Name,
+		IPAddress:   ip.String(),
+		PortMapping: ep.PortMapping,
+	})
 
 	logrus.Infof("ep: %v, ip: %s", ep, ip)
 	if err := configEndpointIPAddressAndRoute(ep, containerInfo); err != nil {
@@ -252,7 +288,52 @@ func ConnectNetwork(networkName string, containerInfo *container.Info) error {
 	return configPortMapping(ep, containerInfo)
 }
 
+// DisconnectNetwork 释放容器在网络中的端点：删除 DNAT 规则和 veth，归还 IP，并从 containerInfo 中删除记录。
+// 资源已经不存在时直接跳过，可以重复执行。
 func DisconnectNetwork(networkName string, containerInfo *container.Info) error {
+	var endpoints []container.Endpoint
+	for i, endpoint := range containerInfo.Networks {
+		if endpoint.Network != networkName {
+			endpoints = append(endpoints, endpoint)
+			continue
+		}
+		if err := disconnectEndpoint(containerInfo.ID, endpoint); err != nil {
+			// 只保留还没有释放的端点，再次断开时不会重复释放已经释放的 IP
+			containerInfo.Networks = append(endpoints, containerInfo.Networks[i:]...)
+			return err
+		}
+	}
+	containerInfo.Networks = endpoints
+
+	return nil
+}
+
+// disconnectEndpoint 删除端口映射和 veth，并释放端点的 IP
+func disconnectEndpoint(containerID string, endpoint container.Endpoint) error {
+	ip := net.ParseIP(endpoint.IPAddress)
+	removePortMapping(endpoint.PortMapping, ip)
+
+	network, ok := networks[endpoint.Network]
+	if !ok {
+		// 网络已经被删除，只需要删除 veth
+		return deleteLink(endpoint.Device)
+	}
+
+	ep := &Endpoint{
+		ID:        fmt.Sprintf("%s-%s", containerID, endpoint.Network),
+		Device:    netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: endpoint.Device}},
+		IPAddress: ip,
+		Network:   network,
+	}
+	if err := drivers[network.Driver].Disconnect(network, ep); err != nil {
+		return err
+	}
+	if ip != nil {
+		if err := IPAllocator.Release(network.IPRange, &ip); err != nil {
+			return fmt.Errorf("release ip %s error: %v", ip, err)
+		}
+	}
+
 	return nil
 }
 
@@ -292,22 +373,23 @@ func DeleteNetwork(networkName string) error {
 		return fmt.Errorf("error remove network driver error: %s", err)
 	}
 
-	return nw.remove(defaultNetworkPath)
+	return nw.remove(config.Get().NetworkPath())
 }
 
 func Init() error {
 	var bridgeDriver = BridgeNetworkDriver{}
 	drivers[bridgeDriver.Name()] = &bridgeDriver
 
-	if _, err := os.Stat(defaultNetworkPath); err != nil {
+	networkPath := config.Get().NetworkPath()
+	if _, err := os.Stat(networkPath); err != nil {
 		if os.IsNotExist(err) {
-			os.MkdirAll(defaultNetworkPath, 0644)
+			os.MkdirAll(networkPath, 0644)
 		} else {
 			return err
 		}
 	}
 
-	filepath.Walk(defaultNetworkPath, func(nwPath string, info os.FileInfo, err error) error {
+	filepath.Walk(networkPath, func(nwPath string, info os.FileInfo, err error) error {
 		if info.IsDir() {
 			return nil
 		}
@@ -0,0 +1,67 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"godocker/internal/container"
+)
+
+// fakeDriver 断开 failDevice 时返回错误
+type fakeDriver struct {
+	failDevice string
+}
+
+func (d *fakeDriver) Name() string { return "fake" }
+
+func (d *fakeDriver) Create(subnet string, name string) (*Network, error) { return nil, nil }
+
+func (d *fakeDriver) Delete(network Network) error { return nil }
+
+func (d *fakeDriver) Connect(network *Network, endpoint *Endpoint) error { return nil }
+
+func (d *fakeDriver) Disconnect(network *Network, endpoint *Endpoint) error {
+	if endpoint.Device.Name == d.failDevice {
+		return fmt.Errorf("disconnect %s error", d.failDevice)
+	}
+	return nil
+}
+
+func TestDisconnectNetworkPartial(t *testing.T) {
+	driver := &fakeDriver{failDevice: "veth-b"}
+	drivers[driver.Name()] = driver
+	_, ipRange, _ := net.ParseCIDR("10.20.0.0/24")
+	networks["test"] = &Network{Name: "test", IPRange: ipRange, Driver: driver.Name()}
+	defer func() {
+		delete(drivers, driver.Name())
+		delete(networks, "test")
+	}()
+
+	info := &container.Info{ID: "1234567890"}
+	for _, device := range []string{"veth-a", "veth-b", "veth-c"} {
+		ip, err := IPAllocator.Allocate(ipRange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info.Networks = append(info.Networks, container.Endpoint{Network: "test", Device: device, IPAddress: ip.String()})
+	}
+	released := info.Networks[0].IPAddress
+
+	// 出错时只保留还没有释放的端点
+	if err := DisconnectNetwork("test", info); err == nil {
+		t.Fatal("expected disconnect error")
+	}
+	if len(info.Networks) != 2 || info.Networks[0].Device != "veth-b" || info.Networks[1].Device != "veth-c" {
+		t.Fatalf("unexpected endpoints %+v", info.Networks)
+	}
+	// 已经释放的 IP 会被重新分配
+	if ip, err := IPAllocator.Allocate(ipRange); err != nil || ip.String() != released {
+		t.Errorf("expected %s to be allocated again, got %v, %v", released, ip, err)
+	}
+
+	driver.failDevice = ""
+	if err := DisconnectNetwork("test", info); err != nil || len(info.Networks) != 0 {
+		t.Errorf("unexpected endpoints %+v, %v", info.Networks, err)
+	}
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"godocker/internal/archive"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyDir 将 src 目录完整复制到 dst，保留权限、属主、时间戳、符号链接、硬链接、设备文件以及扩展属性。
+// src 是镜像层时，overlay 格式的 whiteout 会删除 dst 中对应的文件，opaque 目录会先清空 dst 中的同名目录。
+func copyDir(src, dst string) error {
+	// 硬链接: inode -> 第一次复制出的路径
+	links := make(map[uint64]string)
+	// 目录的时间戳需要在其内容复制完成之后再设置
+	var dirs []string
+
+	err := filepath.Walk(src, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, srcPath)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dst, rel)
+
+		if archive.IsWhiteout(info) {
+			return os.RemoveAll(dstPath)
+		}
+
+		// 上层的文件覆盖下层同名的文件，目录则合并
+		if dstInfo, err := os.Lstat(dstPath); err == nil && !(dstInfo.IsDir() && info.IsDir()) {
+			if err := os.RemoveAll(dstPath); err != nil {
+				return err
+			}
+		}
+
+		if info.IsDir() {
+			if rel != "." && archive.IsOpaque(srcPath) {
+				if err := os.RemoveAll(dstPath); err != nil {
+					return err
+				}
+			}
+			dirs = append(dirs, rel)
+		}
+
+		return copyEntry(srcPath, dstPath, info, links)
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		srcPath, dstPath := filepath.Join(src, dirs[i]), filepath.Join(dst, dirs[i])
+		info, err := os.Lstat(srcPath)
+		if err != nil {
+			return err
+		}
+		if err := setTimes(dstPath, info.Sys().(*syscall.Stat_t)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyEntry 复制单个文件（目录只创建自身），links 用于在 dst 中重建硬链接
+func copyEntry(srcPath, dstPath string, info os.FileInfo, links map[uint64]string) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("unsupported file info of %s", srcPath)
+	}
+
+	switch mode := info.Mode(); {
+	case mode.IsDir():
+		if err := os.Mkdir(dstPath, mode.Perm()); err != nil && !os.IsExist(err) {
+			return err
+		}
+
+	case mode.IsRegular():
+		if st.Nlink > 1 {
+			if target, ok := links[st.Ino]; ok {
+				return os.Link(target, dstPath)
+			}
+			links[st.Ino] = dstPath
+		}
+		if err := copyRegular(srcPath, dstPath, mode.Perm()); err != nil {
+			return err
+		}
+
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(srcPath)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(target, dstPath); err != nil {
+			return err
+		}
+
+	case mode&(os.ModeDevice|os.ModeNamedPipe|os.ModeSocket) != 0:
+		if err := unix.Mknod(dstPath, st.Mode, int(st.Rdev)); err != nil {
+			return fmt.Errorf("mknod %s error: %v", dstPath, err)
+		}
+
+	default:
+		return fmt.Errorf("unknown file type of %s", srcPath)
+	}
+
+	return copyMetadata(srcPath, dstPath, info, st)
+}
+
+func copyRegular(srcPath, dstPath string, perm os.FileMode) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// copyMetadata 复制属主、权限位（包括 setuid/setgid/sticky）、扩展属性以及时间戳
+func copyMetadata(srcPath, dstPath string, info os.FileInfo, st *syscall.Stat_t) error {
+	// 非 root 用户无法修改属主，此时保留当前用户
+	if err := os.Lchown(dstPath, int(st.Uid), int(st.Gid)); err != nil && !os.IsPermission(err) {
+		return fmt.Errorf("lchown %s error: %v", dstPath, err)
+	}
+
+	if info.Mode()&os.ModeSymlink == 0 {
+		if err := unix.Chmod(dstPath, st.Mode&07777); err != nil {
+			return fmt.Errorf("chmod %s error: %v", dstPath, err)
+		}
+	}
+
+	if err := copyXattrs(srcPath, dstPath); err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return nil
+	}
+
+	return setTimes(dstPath, st)
+}
+
+func setTimes(dstPath string, st *syscall.Stat_t) error {
+	ts := []unix.Timespec{
+		unix.NsecToTimespec(syscall.TimespecToNsec(st.Atim)),
+		unix.NsecToTimespec(syscall.TimespecToNsec(st.Mtim)),
+	}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, dstPath, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return fmt.Errorf("set times of %s error: %v", dstPath, err)
+	}
+
+	return nil
+}
+
+// copyXattrs 复制扩展属性，文件系统不支持或者没有权限时忽略
+func copyXattrs(srcPath, dstPath string) error {
+	xattrs, err := archive.ListXattrs(srcPath)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range xattrs {
+		if name == archive.OverlayOpaqueXattr {
+			continue
+		}
+		if err := unix.Lsetxattr(dstPath, name, value, 0); err != nil {
+			if err == unix.ENOTSUP || err == unix.EPERM {
+				continue
+			}
+			return fmt.Errorf("set xattr %s of %s error: %v", name, dstPath, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"godocker/internal/archive"
+
+	"golang.org/x/sys/unix"
+)
+
+// diffDir 比较 rootfs 与 base，将新增或修改的文件复制到 diff，删除的文件在 diff 中记为 overlay whiteout。
+// diff 的格式与 overlay 的 upperdir 相同，可以直接用 archive.TarLayer 打包。
+func diffDir(base, rootfs, diff string) error {
+	links := make(map[uint64]string)
+	// 替换了 base 中文件或符号链接的目录，其中的内容在 base 中都不存在，不能再通过符号链接去比较
+	var replaced string
+
+	err := filepath.Walk(rootfs, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(rootfs, p)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		var baseInfo os.FileInfo
+		if replaced == "" || !strings.HasPrefix(rel, replaced+string(filepath.Separator)) {
+			replaced = ""
+			baseInfo, err = os.Lstat(filepath.Join(base, rel))
+			if err == nil && sameFile(p, filepath.Join(base, rel), info, baseInfo) {
+				return nil
+			}
+			if err != nil && !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTDIR) {
+				return err
+			}
+		}
+
+		if err := ensureParentOf(filepath.Dir(rel), rootfs, diff, links); err != nil {
+			return err
+		}
+		target := filepath.Join(diff, rel)
+		if _, err := os.Lstat(target); err == nil {
+			return nil
+		}
+
+		// 目录由文件替换而来时，下层目录的内容不可见
+		if err := copyEntry(p, target, info, links); err != nil {
+			return err
+		}
+		if info.IsDir() && baseInfo != nil && !baseInfo.IsDir() {
+			replaced = rel
+			return unix.Lsetxattr(target, archive.OverlayOpaqueXattr, []byte("y"), 0)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(base, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(base, p)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		if rootInfo, err := os.Lstat(filepath.Join(rootfs, rel)); err == nil {
+			// 目录被文件或符号链接替换，替换的文件已经复制到 diff，下层目录的内容不可见
+			if info.IsDir() && !rootInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := ensureParentOf(filepath.Dir(rel), rootfs, diff, links); err != nil {
+			return err
+		}
+		if err := unix.Mknod(filepath.Join(diff, rel), unix.S_IFCHR, 0); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+}
+
+// ensureParentOf 按 rootfs 中的属性在 diff 中补齐 dir 及其上级目录
+func ensureParentOf(dir, rootfs, diff string, links map[uint64]string) error {
+	if dir == "." {
+		return nil
+	}
+	if _, err := os.Lstat(filepath.Join(diff, dir)); err == nil {
+		return nil
+	}
+	if err := ensureParentOf(filepath.Dir(dir), rootfs, diff, links); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(filepath.Join(rootfs, dir))
+	if err != nil {
+		return err
+	}
+
+	return copyEntry(filepath.Join(rootfs, dir), filepath.Join(diff, dir), info, links)
+}
+
+// sameFile 比较文件的类型、权限、属主、大小、修改时间以及符号链接的目标
+func sameFile(p, baseP string, info, baseInfo os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	baseSt, baseOk := baseInfo.Sys().(*syscall.Stat_t)
+	if !ok || !baseOk {
+		return false
+	}
+
+	if st.Mode != baseSt.Mode || st.Uid != baseSt.Uid || st.Gid != baseSt.Gid || st.Rdev != baseSt.Rdev {
+		return false
+	}
+	// 目录的修改时间会因为子文件的变化而变化，子文件自身会被单独比较
+	if info.IsDir() {
+		return true
+	}
+	if st.Size != baseSt.Size || st.Mtim != baseSt.Mtim {
+		return false
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(p)
+		baseTarget, baseErr := os.Readlink(baseP)
+		return err == nil && baseErr == nil && target == baseTarget
+	}
+
+	return true
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// StorageDriver 负责容器 rootfs 的组织方式：读写层的创建、与只读层联合挂载、卸载以及删除。
+type StorageDriver interface {
+	// Name return driver name
+	Name() string
+
+	// Create 创建容器的读写层，storageOpt 为 --storage-opt 指定的选项，例如 size
+	Create(id string, storageOpt map[string]string) error
+
+	// Mount 将只读层 lowerDirs（上层在前）与读写层组合为容器的 rootfs，返回 rootfs 路径
+	Mount(id string, lowerDirs []string) (string, error)
+
+	// Unmount 卸载容器的 rootfs
+	Unmount(id string) error
+
+	// Remove 删除容器的读写层及挂载点
+	Remove(id string) error
+
+	// Path 返回容器 rootfs 路径
+	Path(id string) string
+
+	// Diff 将读写层相对于只读层 lowerDirs 的变化打包为镜像层 tar，删除的文件记为 whiteout
+	Diff(id string, lowerDirs []string, w io.Writer) error
+
+	// Usage 返回读写层占用的空间以及配额
+	Usage(id string) (*DiskUsage, error)
+}
+
+// DiskUsage 读写层的磁盘占用，Limit 为 0 表示没有配额
+type DiskUsage struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit"`
+}
+
+var drivers = map[string]func(home string) StorageDriver{
+	"overlay2": NewOverlayDriver,
+	"vfs":      NewVfsDriver,
+}
+
+// New 根据名称创建存储驱动，home 为存放读写层和挂载点的根目录
+func New(name, home string) (StorageDriver, error) {
+	newDriver, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("no such storage driver: %s", name)
+	}
+
+	return newDriver(home), nil
+}
+
+// DefaultName 根据内核支持的文件系统选择存储驱动，不支持 overlay 时退回到 vfs
+func DefaultName() string {
+	if supportsFilesystem("overlay") {
+		return "overlay2"
+	}
+
+	return "vfs"
+}
+
+// Default 创建当前主机默认的存储驱动
+func Default(home string) (StorageDriver, error) {
+	return New(DefaultName(), home)
+}
+
+// supportsFilesystem 检查 /proc/filesystems 中是否包含 fsType
+func supportsFilesystem(fsType string) bool {
+	f, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	// nodev	overlay
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[len(fields)-1] == fsType {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"godocker/internal/archive"
+	"godocker/pkg"
+)
+
+// OverlayDriver 使用 overlayfs 组织容器 rootfs
+// home/write/<id> 为 upperdir，home/work/<id> 为 workdir，home/mnt/<id> 为合并后的挂载点
+// 有配额时 upperdir 和 workdir 为 home/quota/<id> 中的 upper 和 work，overlay 要求它们位于同一个挂载点
+type OverlayDriver struct {
+	home string
+}
+
+func NewOverlayDriver(home string) StorageDriver {
+	return &OverlayDriver{home: home}
+}
+
+// Name return driver name
+func (d *OverlayDriver) Name() string {
+	return "overlay2"
+}
+
+func (d *OverlayDriver) upperDir(id string) string {
+	if q := d.quota(id); q.exists() {
+		return q.dir("upper")
+	}
+
+	return path.Join(d.home, "write", id)
+}
+
+func (d *OverlayDriver) workDir(id string) string {
+	if q := d.quota(id); q.exists() {
+		return q.dir("work")
+	}
+
+	return path.Join(d.home, "work", id)
+}
+
+func (d *OverlayDriver) quota(id string) quota {
+	return quota{home: d.home, id: id}
+}
+
+// Create 创建 upperdir、workdir 以及挂载点，指定 size 时 upperdir 和 workdir 位于同一个限制大小的文件系统中
+func (d *OverlayDriver) Create(id string, storageOpt map[string]string) error {
+	size, err := QuotaSize(storageOpt)
+	if err != nil {
+		return err
+	}
+
+	if size > 0 {
+		if err := d.quota(id).create(size); err != nil {
+			return fmt.Errorf("create quota of %s error: %v", id, err)
+		}
+	}
+	for _, dir := range []string{d.upperDir(id), d.workDir(id), d.Path(id)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("mkdir %s error: %v", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// Mount mount -t overlay overlay -o lowerdir=...,upperdir=...,workdir=... <mnt>，已经挂载时直接返回
+func (d *OverlayDriver) Mount(id string, lowerDirs []string) (string, error) {
+	if len(lowerDirs) == 0 {
+		return "", fmt.Errorf("overlay mount %s need at least one lower dir", id)
+	}
+
+	// 重启主机后配额的挂载已经不存在，需要重新挂载
+	if err := d.quota(id).mount(); err != nil {
+		return "", err
+	}
+
+	// 重新启动已停止的容器时，合并目录仍然挂载着，只有挂载的是同样的只读层和读写层时才复用
+	target := d.Path(id)
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		strings.Join(lowerDirs, ":"), d.upperDir(id), d.workDir(id))
+	if isMountPoint(target) {
+		if !mountedWith(target, "lowerdir="+strings.Join(lowerDirs, ":"), "upperdir="+d.upperDir(id)) {
+			return "", fmt.Errorf("%s is already mounted with other layers", target)
+		}
+		return target, nil
+	}
+	if err := syscall.Mount("overlay", target, "overlay", 0, options); err != nil {
+		return "", fmt.Errorf("mount overlay %s error: %v", target, err)
+	}
+
+	return target, nil
+}
+
+// Unmount 卸载合并目录以及配额，未挂载时直接返回
+func (d *OverlayDriver) Unmount(id string) error {
+	if err := unmount(d.Path(id)); err != nil {
+		return err
+	}
+
+	return d.quota(id).unmount()
+}
+
+// Remove 删除 upperdir、workdir、挂载点以及配额
+func (d *OverlayDriver) Remove(id string) error {
+	if err := d.quota(id).remove(); err != nil {
+		return err
+	}
+	for _, dir := range []string{d.Path(id), d.workDir(id), d.upperDir(id)} {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("remove %s error: %v", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// Path 返回合并后的挂载点
+func (d *OverlayDriver) Path(id string) string {
+	return path.Join(d.home, "mnt", id)
+}
+
+// Diff upperdir 中记录的正是容器对只读层的修改，删除的文件为 overlay whiteout
+func (d *OverlayDriver) Diff(id string, lowerDirs []string, w io.Writer) error {
+	if err := d.quota(id).mount(); err != nil {
+		return err
+	}
+
+	return archive.TarLayer(d.upperDir(id), w)
+}
+
+// Usage upperdir 的大小即容器写入的数据量
+func (d *OverlayDriver) Usage(id string) (*DiskUsage, error) {
+	q := d.quota(id)
+	if err := q.mount(); err != nil {
+		return nil, err
+	}
+
+	used, err := pkg.DirSize(d.upperDir(id))
+	if err != nil {
+		return nil, err
+	}
+
+	return &DiskUsage{Used: used, Limit: q.limit()}, nil
+}
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"godocker/pkg"
+
+	"golang.org/x/sys/unix"
+)
+
+// 读写层配额：在 <home>/quota/<id>.img 创建指定大小的 ext4 镜像，通过 loop 设备挂载到 <home>/quota/<id>，
+// 读写层位于其中的子目录，写满后容器内的写入返回 ENOSPC。
+
+// minQuotaSize ext4 日志等元数据需要的最小空间
+const minQuotaSize = 16 << 20
+
+// ParseStorageOpt 解析 --storage-opt key=value，并检查选项是否合法
+func ParseStorageOpt(opts []string) (map[string]string, error) {
+	if len(opts) == 0 {
+		return nil, nil
+	}
+
+	storageOpt := make(map[string]string, len(opts))
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid storage option %q, expected key=value", opt)
+		}
+		storageOpt[kv[0]] = kv[1]
+	}
+	if _, err := QuotaSize(storageOpt); err != nil {
+		return nil, err
+	}
+
+	return storageOpt, nil
+}
+
+// QuotaSize 解析 --storage-opt，目前只支持 size，返回 0 表示不限制
+func QuotaSize(storageOpt map[string]string) (int64, error) {
+	var size int64
+	for key, value := range storageOpt {
+		switch strings.ToLower(key) {
+		case "size":
+			s, err := pkg.ParseSize(value)
+			if err != nil {
+				return 0, err
+			}
+			if s < minQuotaSize {
+				return 0, fmt.Errorf("storage size %s is too small, the minimum is 16M", value)
+			}
+			size = s
+		default:
+			return 0, fmt.Errorf("unknown storage option %s", key)
+		}
+	}
+
+	return size, nil
+}
+
+type quota struct {
+	home string
+	id   string
+}
+
+func (q quota) image() string {
+	return path.Join(q.home, "quota", q.id+".img")
+}
+
+func (q quota) mountPoint() string {
+	return path.Join(q.home, "quota", q.id)
+}
+
+func (q quota) dir(sub string) string {
+	return path.Join(q.mountPoint(), sub)
+}
+
+// exists 容器是否设置了配额
+func (q quota) exists() bool {
+	_, err := os.Stat(q.image())
+	return err == nil
+}
+
+// limit 返回配额大小，没有配额时返回 0
+func (q quota) limit() int64 {
+	info, err := os.Stat(q.image())
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// create 创建并格式化 ext4 镜像，然后挂载。重新启动容器时镜像已经存在，只需要挂载
+func (q quota) create(size int64) error {
+	if q.exists() {
+		return q.mount()
+	}
+	if err := os.MkdirAll(q.mountPoint(), 0700); err != nil {
+		return err
+	}
+
+	// 稀疏文件，只占用实际写入的空间
+	f, err := os.OpenFile(q.image(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(size)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	// -m 0 不保留 root 的空间，容器中的 root 同样受限
+	if output, err := exec.Command("mkfs.ext4", "-q", "-F", "-m", "0", q.image()).CombinedOutput(); err != nil {
+		return fmt.Errorf("mkfs.ext4 %s error: %v, %s", q.image(), err, strings.TrimSpace(string(output)))
+	}
+
+	return q.mount()
+}
+
+// mount 挂载 ext4 镜像，已挂载或者没有配额时直接返回
+func (q quota) mount() error {
+	if !q.exists() || isMountPoint(q.mountPoint()) {
+		return nil
+	}
+
+	return mountLoop(q.image(), q.mountPoint())
+}
+
+// unmount 卸载 ext4 镜像，loop 设备在卸载后自动释放
+func (q quota) unmount() error {
+	return unmount(q.mountPoint())
+}
+
+// remove 卸载并删除 ext4 镜像
+func (q quota) remove() error {
+	if err := q.unmount(); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(q.mountPoint()); err != nil {
+		return err
+	}
+	if err := os.Remove(q.image()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func unmount(target string) error {
+	if err := syscall.Unmount(target, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL && !os.IsNotExist(err) {
+		return fmt.Errorf("umount %s error: %v", target, err)
+	}
+
+	return nil
+}
+
+// isMountPoint 与父目录不在同一个设备上即为挂载点
+func isMountPoint(dir string) bool {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return false
+	}
+	parent, err := os.Stat(path.Dir(dir))
+	if err != nil {
+		return false
+	}
+
+	return info.Sys().(*syscall.Stat_t).Dev != parent.Sys().(*syscall.Stat_t).Dev
+}
+
+// mountedWith dir 的挂载参数中是否包含所有 options
+func mountedWith(dir string, options ...string) bool {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	// 1135 29 0:98 / /var/lib/godocker/overlay2/mnt/web rw,relatime shared:600 - overlay overlay rw,lowerdir=...,upperdir=...,workdir=...
+	var mountOptions []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 4 && fields[4] == dir {
+			// 同一个目录挂载多次时最后一个生效
+			mountOptions = strings.Split(fields[len(fields)-1], ",")
+		}
+	}
+
+	for _, option := range options {
+		found := false
+		for _, opt := range mountOptions {
+			if opt == option {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return len(mountOptions) > 0
+}
+
+// mountLoop 将镜像文件关联到空闲的 loop 设备并挂载，设置 autoclear 使卸载后自动解除关联
+func mountLoop(image, target string) error {
+	ctl, err := os.OpenFile("/dev/loop-control", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("open loop-control error: %v", err)
+	}
+	defer ctl.Close()
+
+	img, err := os.OpenFile(image, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	// 其他进程可能同时拿到同一个空闲设备，LOOP_SET_FD 返回 EBUSY 时重试
+	for i := 0; i < 10; i++ {
+		index, _, errno := unix.Syscall(unix.SYS_IOCTL, ctl.Fd(), unix.LOOP_CTL_GET_FREE, 0)
+		if errno != 0 {
+			return fmt.Errorf("get free loop device error: %v", errno)
+		}
+
+		device := fmt.Sprintf("/dev/loop%d", index)
+		if err := mountLoopDevice(device, img, image, target); err != unix.EBUSY {
+			return err
+		}
+	}
+
+	return fmt.Errorf("no free loop device")
+}
+
+// mountLoopDevice 关联镜像文件并挂载，出错时先用仍然打开的设备解除关联再关闭，避免 loop 设备泄漏。
+// 设备已经被其他进程使用时返回 EBUSY
+func mountLoopDevice(device string, img *os.File, image, target string) error {
+	loop, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("open %s error: %v", device, err)
+	}
+	// 挂载之后关闭设备，autoclear 在卸载时生效
+	defer loop.Close()
+
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, loop.Fd(), unix.LOOP_SET_FD, img.Fd()); errno != 0 {
+		if errno == unix.EBUSY {
+			return errno
+		}
+		return fmt.Errorf("set loop device %s error: %v", device, errno)
+	}
+
+	info := unix.LoopInfo64{Flags: unix.LO_FLAGS_AUTOCLEAR}
+	copy(info.File_name[:], image)
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, loop.Fd(), unix.LOOP_SET_STATUS64, uintptr(unsafe.Pointer(&info))); errno != 0 {
+		_, _, _ = unix.Syscall(unix.SYS_IOCTL, loop.Fd(), unix.LOOP_CLR_FD, 0)
+		return fmt.Errorf("set loop device %s status error: %v", device, errno)
+	}
+
+	if err := syscall.Mount(device, target, "ext4", 0, ""); err != nil {
+		_, _, _ = unix.Syscall(unix.SYS_IOCTL, loop.Fd(), unix.LOOP_CLR_FD, 0)
+		return fmt.Errorf("mount %s to %s error: %v", device, target, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package storage
+
+import "testing"
+
+func TestParseStorageOpt(t *testing.T) {
+	opts, err := ParseStorageOpt([]string{"size=2G"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := QuotaSize(opts)
+	if err != nil || size != 2<<30 {
+		t.Errorf("expected %d, got %d, %v", 2<<30, size, err)
+	}
+
+	if opts, err := ParseStorageOpt(nil); err != nil || opts != nil {
+		t.Errorf("expected no storage options, got %v, %v", opts, err)
+	}
+
+	for _, opt := range []string{"size", "=2G", "size=1m", "size=abc", "inodes=100"} {
+		if _, err := ParseStorageOpt([]string{opt}); err == nil {
+			t.Errorf("expected error for %q", opt)
+		}
+	}
+}
+
+func TestMountedWith(t *testing.T) {
+	if !mountedWith("/proc") {
+		t.Error("/proc should be a mount point")
+	}
+	if mountedWith("/proc", "upperdir=/nonexistent") {
+		t.Error("/proc should not be mounted with upperdir")
+	}
+	if mountedWith(t.TempDir()) {
+		t.Error("temp dir should not be a mount point")
+	}
+}
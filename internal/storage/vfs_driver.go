@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"godocker/internal/archive"
+	"godocker/pkg"
+)
+
+// VfsDriver 不依赖任何联合文件系统，把只读层完整复制到读写层目录，读写层目录即为容器 rootfs
+// 适用于不支持 overlay 的文件系统以及没有挂载权限的环境
+type VfsDriver struct {
+	home string
+}
+
+func NewVfsDriver(home string) StorageDriver {
+	return &VfsDriver{home: home}
+}
+
+// Name return driver name
+func (d *VfsDriver) Name() string {
+	return "vfs"
+}
+
+func (d *VfsDriver) quota(id string) quota {
+	return quota{home: d.home, id: id}
+}
+
+// Create 创建读写层目录，指定 size 时读写层位于限制大小的文件系统中，复制的只读层同样计入配额
+func (d *VfsDriver) Create(id string, storageOpt map[string]string) error {
+	size, err := QuotaSize(storageOpt)
+	if err != nil {
+		return err
+	}
+
+	if size > 0 {
+		if err := d.quota(id).create(size); err != nil {
+			return fmt.Errorf("create quota of %s error: %v", id, err)
+		}
+	}
+	dir := d.Path(id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("mkdir %s error: %v", dir, err)
+	}
+
+	return nil
+}
+
+// Mount 按从下到上的顺序把只读层复制到读写层。
+// 读写层已经有内容时说明容器已经初始化过，直接返回，避免覆盖容器内的修改。
+func (d *VfsDriver) Mount(id string, lowerDirs []string) (string, error) {
+	if err := d.quota(id).mount(); err != nil {
+		return "", err
+	}
+
+	target := d.Path(id)
+	files, err := ioutil.ReadDir(target)
+	if err != nil {
+		return "", fmt.Errorf("read dir %s error: %v", target, err)
+	}
+	if len(files) > 0 {
+		return target, nil
+	}
+
+	for i := len(lowerDirs) - 1; i >= 0; i-- {
+		if err := copyDir(lowerDirs[i], target); err != nil {
+			return "", fmt.Errorf("copy %s to %s error: %v", lowerDirs[i], target, err)
+		}
+	}
+
+	return target, nil
+}
+
+// Unmount vfs 只有配额需要卸载
+func (d *VfsDriver) Unmount(id string) error {
+	return d.quota(id).unmount()
+}
+
+// Remove 删除读写层目录以及配额
+func (d *VfsDriver) Remove(id string) error {
+	if err := d.quota(id).remove(); err != nil {
+		return err
+	}
+	dir := d.Path(id)
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("remove %s error: %v", dir, err)
+	}
+
+	return nil
+}
+
+// Path 返回读写层目录，有配额时为 home/quota/<id>/rootfs
+func (d *VfsDriver) Path(id string) string {
+	if q := d.quota(id); q.exists() {
+		return q.dir("rootfs")
+	}
+
+	return path.Join(d.home, "write", id)
+}
+
+// Diff 重新复制一份只读层，与读写层逐个文件比较得到变化
+func (d *VfsDriver) Diff(id string, lowerDirs []string, w io.Writer) error {
+	if err := d.quota(id).mount(); err != nil {
+		return err
+	}
+
+	tmpDir := path.Join(d.home, "tmp")
+	if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir(tmpDir, "diff-"+id+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	base, diff := path.Join(dir, "base"), path.Join(dir, "diff")
+	for _, p := range []string{base, diff} {
+		if err := os.Mkdir(p, 0755); err != nil {
+			return err
+		}
+	}
+	for i := len(lowerDirs) - 1; i >= 0; i-- {
+		if err := copyDir(lowerDirs[i], base); err != nil {
+			return fmt.Errorf("copy %s error: %v", lowerDirs[i], err)
+		}
+	}
+
+	if err := diffDir(base, d.Path(id), diff); err != nil {
+		return fmt.Errorf("diff %s error: %v", id, err)
+	}
+
+	return archive.TarLayer(diff, w)
+}
+
+// Usage 读写层包含复制的只读层，返回整个目录的大小
+func (d *VfsDriver) Usage(id string) (*DiskUsage, error) {
+	q := d.quota(id)
+	if err := q.mount(); err != nil {
+		return nil, err
+	}
+
+	used, err := pkg.DirSize(d.Path(id))
+	if err != nil {
+		return nil, err
+	}
+
+	return &DiskUsage{Used: used, Limit: q.limit()}, nil
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+
+	"godocker/internal/archive"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestVfsDriver(t *testing.T) {
+	home := t.TempDir()
+	lower := path.Join(home, "busybox")
+	if err := os.MkdirAll(path.Join(lower, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(lower, "bin", "busybox"), []byte("busybox"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path.Join(lower, "bin", "busybox"), 0755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("busybox", path.Join(lower, "bin", "sh")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(path.Join(lower, "bin", "busybox"), path.Join(lower, "bin", "ls")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(path.Join(lower, "fifo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	xattr := unix.Lsetxattr(path.Join(lower, "bin", "busybox"), "user.godocker", []byte("test"), 0) == nil
+
+	driver := NewVfsDriver(home)
+	if err := driver.Create("test", nil); err != nil {
+		t.Fatalf("create error %v", err)
+	}
+	rootfs, err := driver.Mount("test", []string{lower})
+	if err != nil {
+		t.Fatalf("mount error %v", err)
+	}
+
+	info, err := os.Stat(path.Join(rootfs, "bin", "busybox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0755|os.ModeSetuid {
+		t.Errorf("mode of busybox: %v", info.Mode())
+	}
+	if target, err := os.Readlink(path.Join(rootfs, "bin", "sh")); err != nil || target != "busybox" {
+		t.Errorf("readlink sh: %s, %v", target, err)
+	}
+	ls, err := os.Stat(path.Join(rootfs, "bin", "ls"))
+	if err != nil || !os.SameFile(info, ls) {
+		t.Errorf("ls should be a hard link of busybox: %v", err)
+	}
+	if fifo, err := os.Lstat(path.Join(rootfs, "fifo")); err != nil || fifo.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("fifo: %v", err)
+	}
+	if xattr {
+		value := make([]byte, 16)
+		n, err := unix.Lgetxattr(path.Join(rootfs, "bin", "busybox"), "user.godocker", value)
+		if err != nil || string(value[:n]) != "test" {
+			t.Errorf("xattr: %s, %v", value[:n], err)
+		}
+	}
+
+	// 再次挂载不应该覆盖容器内的修改
+	if err := os.WriteFile(path.Join(rootfs, "bin", "busybox"), []byte("changed"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := driver.Mount("test", []string{lower}); err != nil {
+		t.Fatalf("mount error %v", err)
+	}
+	if content, _ := os.ReadFile(path.Join(rootfs, "bin", "busybox")); string(content) != "changed" {
+		t.Errorf("content of busybox: %s", content)
+	}
+
+	if err := driver.Remove("test"); err != nil {
+		t.Fatalf("remove error %v", err)
+	}
+}
+
+func TestVfsDiff(t *testing.T) {
+	home := t.TempDir()
+	lower := path.Join(home, "busybox")
+	for _, dir := range []string{"etc", "bin"} {
+		if err := os.MkdirAll(path.Join(lower, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"etc/passwd", "etc/hosts", "bin/sh"} {
+		if err := os.WriteFile(path.Join(lower, file), []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	driver := NewVfsDriver(home)
+	if err := driver.Create("test", nil); err != nil {
+		t.Fatal(err)
+	}
+	rootfs, err := driver.Mount("test", []string{lower})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path.Join(rootfs, "etc", "passwd")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(rootfs, "etc", "hostname"), []byte("godocker"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := driver.Diff("test", []string{lower}, &buf); err != nil {
+		t.Fatalf("diff error %v", err)
+	}
+
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if strings.Join(names, ",") != "etc/,etc/hostname,etc/.wh.passwd" {
+		t.Errorf("diff entries: %v", names)
+	}
+}
+
+func TestDiffDirReplaced(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("whiteout need root")
+	}
+
+	home := t.TempDir()
+	base, rootfs, diff := path.Join(home, "base"), path.Join(home, "rootfs"), path.Join(home, "diff")
+	for _, dir := range []string{"base/etc", "rootfs/opt", "rootfs/var", "diff"} {
+		if err := os.MkdirAll(path.Join(home, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"base/etc/passwd", "base/opt", "rootfs/etc", "rootfs/opt/x"} {
+		if err := os.WriteFile(path.Join(home, file), []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// base 中的 var 是指向 rootfs 之外的符号链接，rootfs 中替换为目录
+	if err := os.Symlink(home, path.Join(base, "var")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(rootfs, "var", "base"), []byte("var/base"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := diffDir(base, rootfs, diff); err != nil {
+		t.Fatalf("diff error %v", err)
+	}
+
+	if info, err := os.Lstat(path.Join(diff, "etc")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("etc should be a file: %v", err)
+	}
+	for _, file := range []string{"opt/x", "var/base"} {
+		if _, err := os.Lstat(path.Join(diff, file)); err != nil {
+			t.Errorf("%s should be copied: %v", file, err)
+		}
+	}
+	for _, dir := range []string{"opt", "var"} {
+		if !archive.IsOpaque(path.Join(diff, dir)) {
+			t.Errorf("%s should be opaque", dir)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package volume
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"syscall"
+	"time"
+
+	"godocker/internal/archive"
+	"godocker/internal/config"
+	"godocker/pkg"
+)
+
+const (
+	DefaultDriver = "local"
+	metadataFile  = "volume.json"
+	dataDir       = "_data"
+	lockFile      = "volumes.lock"
+)
+
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// Volume 命名卷，数据保存在 <root>/volumes/<name>/_data 中
+type Volume struct {
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver"`
+	Mountpoint string            `json:"mountpoint"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	// Refs 引用该卷的容器，不为空时不允许删除
+	Refs []string `json:"refs"`
+	// Populated 第一次挂载时已经用镜像中挂载点的内容初始化过
+	Populated bool `json:"populated"`
+	// Anonymous 没有指定卷名时自动创建的匿名卷，最后一个引用的容器删除时一起删除
+	Anonymous bool `json:"anonymous,omitempty"`
+}
+
+// Referenced 卷是否被容器引用
+func (v *Volume) Referenced(container string) bool {
+	for _, ref := range v.Refs {
+		if ref == container {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Store 命名卷存储
+// <root>/<name>/volume.json 卷的元数据
+// <root>/<name>/_data       卷的数据，bind mount 到容器中
+type Store struct {
+	Root string
+}
+
+// NewStore 返回当前配置下的卷存储
+func NewStore() *Store {
+	return &Store{Root: path.Join(config.Get().Root, "volumes")}
+}
+
+// IsName 判断 -v 的源是卷名还是宿主机路径
+func IsName(source string) bool {
+	return namePattern.MatchString(source)
+}
+
+func (s *Store) volumePath(name string) string {
+	return path.Join(s.Root, name)
+}
+
+// Path 返回卷的数据目录，即 bind mount 到容器中的目录
+func (s *Store) Path(name string) string {
+	return path.Join(s.volumePath(name), dataDir)
+}
+
+// Create 创建命名卷，name 为空时生成随机的名字，卷已存在时直接返回
+func (s *Store) Create(name string, labels map[string]string) (*Volume, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return s.create(name, labels, false)
+}
+
+func (s *Store) create(name string, labels map[string]string, anonymous bool) (*Volume, error) {
+	if name == "" {
+		name = RandomName()
+	}
+	if !IsName(name) {
+		return nil, fmt.Errorf("invalid volume name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	if v, err := s.load(name); err == nil {
+		return v, nil
+	}
+
+	v := &Volume{
+		Name:       name,
+		Driver:     DefaultDriver,
+		Mountpoint: s.Path(name),
+		Labels:     labels,
+		CreatedAt:  time.Now(),
+		Refs:       []string{},
+		Anonymous:  anonymous,
+	}
+	if err := os.MkdirAll(v.Mountpoint, 0755); err != nil {
+		return nil, err
+	}
+	if err := s.dump(v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Get 读取命名卷
+func (s *Store) Get(name string) (*Volume, error) {
+	v, err := s.load(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such volume: %s", name)
+		}
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// List 返回所有命名卷，按名字排序
+func (s *Store) List() ([]*Volume, error) {
+	entries, err := ioutil.ReadDir(s.Root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var volumes []*Volume
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		v, err := s.load(entry.Name())
+		if err != nil {
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Name < volumes[j].Name
+	})
+
+	return volumes, nil
+}
+
+// Remove 删除命名卷，卷仍然被容器引用时拒绝删除
+func (s *Store) Remove(name string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	v, err := s.Get(name)
+	if err != nil {
+		return err
+	}
+	if len(v.Refs) > 0 {
+		return fmt.Errorf("volume %s is in use by containers %v", name, v.Refs)
+	}
+
+	return os.RemoveAll(s.volumePath(name))
+}
+
+// Prune 删除所有没有被容器引用的卷，返回删除的卷以及释放的空间
+func (s *Store) Prune() ([]string, int64, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer unlock()
+
+	volumes, err := s.List()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var removed []string
+	var reclaimed int64
+	for _, v := range volumes {
+		if len(v.Refs) > 0 {
+			continue
+		}
+		size, _ := pkg.DirSize(v.Mountpoint)
+		if err := os.RemoveAll(s.volumePath(v.Name)); err != nil {
+			return removed, reclaimed, err
+		}
+		removed = append(removed, v.Name)
+		reclaimed += size
+	}
+
+	return removed, reclaimed, nil
+}
+
+// Acquire 记录容器对卷的引用，卷不存在时自动创建
+func (s *Store) Acquire(name, container string) (*Volume, error) {
+	return s.acquire(name, container, false)
+}
+
+// AcquireAnonymous 和 Acquire 相同，卷不存在时创建为匿名卷
+func (s *Store) AcquireAnonymous(name, container string) (*Volume, error) {
+	return s.acquire(name, container, true)
+}
+
+func (s *Store) acquire(name, container string, anonymous bool) (*Volume, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	v, err := s.create(name, nil, anonymous)
+	if err != nil {
+		return nil, err
+	}
+	if v.Referenced(container) {
+		return v, nil
+	}
+	v.Refs = append(v.Refs, container)
+
+	return v, s.dump(v)
+}
+
+// Release 删除容器对卷的引用，卷已经不存在时忽略。匿名卷没有引用之后直接删除
+func (s *Store) Release(name, container string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	v, err := s.load(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	refs := v.Refs[:0]
+	for _, ref := range v.Refs {
+		if ref != container {
+			refs = append(refs, ref)
+		}
+	}
+	v.Refs = refs
+	if v.Anonymous && len(v.Refs) == 0 {
+		return os.RemoveAll(s.volumePath(name))
+	}
+
+	return s.dump(v)
+}
+
+// Populate 第一次挂载时，卷为空且镜像中挂载点有内容，则把 src 中的内容复制到卷中。
+// src 需要是已经在 rootfs 中解析过的路径，本身是符号链接或者不是目录时不复制
+func (s *Store) Populate(name, src string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	v, err := s.Get(name)
+	if err != nil {
+		return err
+	}
+	if v.Populated {
+		return nil
+	}
+
+	if empty, err := isEmptyDir(v.Mountpoint); err != nil {
+		return err
+	} else if empty {
+		if info, err := os.Lstat(src); err == nil && info.IsDir() {
+			if err := copyDir(src, v.Mountpoint); err != nil {
+				return fmt.Errorf("populate volume %s from %s error: %v", name, src, err)
+			}
+		}
+	}
+	v.Populated = true
+
+	return s.dump(v)
+}
+
+func (s *Store) load(name string) (*Volume, error) {
+	if !IsName(name) {
+		return nil, os.ErrNotExist
+	}
+	content, err := ioutil.ReadFile(path.Join(s.volumePath(name), metadataFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var v Volume
+	if err := json.Unmarshal(content, &v); err != nil {
+		return nil, fmt.Errorf("unmarshal volume %s error: %v", name, err)
+	}
+
+	return &v, nil
+}
+
+func (s *Store) dump(v *Volume) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	filename := path.Join(s.volumePath(v.Name), metadataFile)
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filename)
+}
+
+// lock 多个 godocker 进程可能同时修改卷的引用，修改前加文件锁
+func (s *Store) lock() (func(), error) {
+	if err := os.MkdirAll(s.Root, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path.Join(s.Root, lockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+func isEmptyDir(dir string) (bool, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	_, err = f.Readdirnames(1)
+	if err == io.EOF {
+		return true, nil
+	}
+
+	return false, err
+}
+
+// copyDir 通过 tar 流复制目录，保留属主、权限以及扩展属性
+func copyDir(src, dst string) error {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(archive.Tar(src, w))
+	}()
+
+	err := archive.Untar(r, dst)
+	r.Close()
+	if err != nil {
+		return err
+	}
+
+	// 卷的根目录沿用挂载点的属主和权限
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	if err := os.Chown(dst, int(st.Uid), int(st.Gid)); err != nil && !os.IsPermission(err) {
+		return err
+	}
+
+	return syscall.Chmod(dst, st.Mode&07777)
+}
+
+// RandomName 生成匿名卷的名字
+func RandomName() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return pkg.RandStringBytes(64)
+	}
+
+	return hex.EncodeToString(b)
+}
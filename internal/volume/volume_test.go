@@ -0,0 +1,122 @@
+package volume
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestVolumeRefs(t *testing.T) {
+	store := &Store{Root: t.TempDir()}
+
+	v, err := store.Acquire("data", "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Acquire("data", "c2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create("unused", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create("bad/name", nil); err == nil {
+		t.Error("expected error for invalid volume name")
+	}
+
+	// 第一次挂载时用 src 初始化，之后不再复制
+	src := t.TempDir()
+	if err := os.WriteFile(path.Join(src, "file"), []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Populate("data", src); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(path.Join(v.Mountpoint, "file")); err != nil || string(content) != "image" {
+		t.Errorf("populated file: %s, %v", content, err)
+	}
+	if err := os.Remove(path.Join(v.Mountpoint, "file")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Populate("data", src); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(v.Mountpoint, "file")); err == nil {
+		t.Error("volume should only be populated once")
+	}
+
+	// 挂载点是符号链接时不复制链接指向的内容
+	link := path.Join(t.TempDir(), "link")
+	if err := os.Symlink(src, link); err != nil {
+		t.Fatal(err)
+	}
+	linked, err := store.Create("link", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Populate("link", link); err != nil {
+		t.Fatal(err)
+	}
+	if empty, err := isEmptyDir(linked.Mountpoint); err != nil || !empty {
+		t.Errorf("volume populated through symlink: %v, %v", empty, err)
+	}
+	if err := store.Remove("link"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Remove("data"); err == nil {
+		t.Error("expected error when removing a volume in use")
+	}
+	removed, _, err := store.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != "unused" {
+		t.Errorf("pruned volumes: %v", removed)
+	}
+
+	for _, container := range []string{"c1", "c2", "c2"} {
+		if err := store.Release("data", container); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Remove("data"); err != nil {
+		t.Fatal(err)
+	}
+	if volumes, err := store.List(); err != nil || len(volumes) != 0 {
+		t.Errorf("volumes after remove: %v, %v", volumes, err)
+	}
+}
+
+func TestAnonymousVolume(t *testing.T) {
+	store := &Store{Root: t.TempDir()}
+
+	name := RandomName()
+	for _, container := range []string{"c1", "c2"} {
+		if _, err := store.AcquireAnonymous(name, container); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Release(name, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(name); err != nil {
+		t.Errorf("anonymous volume removed while in use: %v", err)
+	}
+
+	// 最后一个引用释放后删除匿名卷，命名卷保留
+	if err := store.Release(name, "c2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.volumePath(name)); !os.IsNotExist(err) {
+		t.Errorf("anonymous volume not removed: %v", err)
+	}
+	if _, err := store.Acquire("named", "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Release("named", "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("named"); err != nil {
+		t.Errorf("named volume removed: %v", err)
+	}
+}
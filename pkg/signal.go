@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var signals = map[string]syscall.Signal{
+	"HUP":    syscall.SIGHUP,
+	"INT":    syscall.SIGINT,
+	"QUIT":   syscall.SIGQUIT,
+	"ABRT":   syscall.SIGABRT,
+	"KILL":   syscall.SIGKILL,
+	"USR1":   syscall.SIGUSR1,
+	"USR2":   syscall.SIGUSR2,
+	"PIPE":   syscall.SIGPIPE,
+	"ALRM":   syscall.SIGALRM,
+	"TERM":   syscall.SIGTERM,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"STOP":   syscall.SIGSTOP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"WINCH":  syscall.SIGWINCH,
+	"PWR":    syscall.SIGPWR,
+	"SYS":    syscall.SIGSYS,
+	"URG":    syscall.SIGURG,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+	"VTALRM": syscall.SIGVTALRM,
+	"PROF":   syscall.SIGPROF,
+	"IO":     syscall.SIGIO,
+}
+
+// ParseSignal 将 9、KILL、SIGKILL 这样的信号转换为 syscall.Signal，kill -s 和镜像的 StopSignal 使用它解析
+func ParseSignal(signal string) (syscall.Signal, error) {
+	signal = strings.ToUpper(strings.TrimSpace(signal))
+	if n, err := strconv.Atoi(signal); err == nil {
+		if n <= 0 || n > 64 {
+			return 0, fmt.Errorf("invalid signal: %s", signal)
+		}
+		return syscall.Signal(n), nil
+	}
+
+	if sig, ok := signals[strings.TrimPrefix(signal, "SIG")]; ok {
+		return sig, nil
+	}
+
+	return 0, fmt.Errorf("invalid signal: %s", signal)
+}
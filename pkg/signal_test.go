@@ -0,0 +1,29 @@
+package pkg
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := map[string]syscall.Signal{
+		"9":       syscall.SIGKILL,
+		"KILL":    syscall.SIGKILL,
+		"SIGKILL": syscall.SIGKILL,
+		"sigterm": syscall.SIGTERM,
+		"usr1":    syscall.SIGUSR1,
+		"34":      syscall.Signal(34),
+	}
+	for signal, expected := range tests {
+		actual, err := ParseSignal(signal)
+		if err != nil || actual != expected {
+			t.Errorf("parse %s: expected %d, got %d, %v", signal, expected, actual, err)
+		}
+	}
+
+	for _, signal := range []string{"", "0", "65", "SIG", "FOO"} {
+		if _, err := ParseSignal(signal); err == nil {
+			t.Errorf("expected error for %q", signal)
+		}
+	}
+}
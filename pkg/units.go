@@ -0,0 +1,38 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var sizePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([kmgtp]?)(?:i?b)?$`)
+
+// ParseSize 将 100m、1.5g、512k、1024 这样的大小转换为字节数，单位按 1024 进制，
+// 内存限制、tmpfs 大小等都使用它解析
+func ParseSize(size string) (int64, error) {
+	matches := sizePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(size)))
+	if matches == nil {
+		return 0, fmt.Errorf("invalid size: %q", size)
+	}
+
+	value, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %q", size)
+	}
+	switch matches[2] {
+	case "p":
+		value *= 1 << 50
+	case "t":
+		value *= 1 << 40
+	case "g":
+		value *= 1 << 30
+	case "m":
+		value *= 1 << 20
+	case "k":
+		value *= 1 << 10
+	}
+
+	return int64(value), nil
+}
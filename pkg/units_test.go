@@ -0,0 +1,29 @@
+package pkg
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"1024":  1024,
+		"64m":   64 << 20,
+		"64M":   64 << 20,
+		"64MB":  64 << 20,
+		"64MiB": 64 << 20,
+		"1.5g":  3 << 29,
+		"512k":  512 << 10,
+		"2G":    2 << 30,
+		"1t":    1 << 40,
+	}
+	for size, expected := range tests {
+		actual, err := ParseSize(size)
+		if err != nil || actual != expected {
+			t.Errorf("parse %s: expected %d, got %d, %v", size, expected, actual, err)
+		}
+	}
+
+	for _, size := range []string{"", "m", "-1m", "10x", "1..5g"} {
+		if _, err := ParseSize(size); err == nil {
+			t.Errorf("expected error for %q", size)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package pkg
 import (
 	"math/rand"
 	"os"
+	"path/filepath"
+	"syscall"
 	"time"
 )
 
@@ -28,3 +30,27 @@ func PathExists(path string) (bool, error) {
 
 	return false, err
 }
+
+// DirSize 统计目录下所有文件的大小，硬链接只统计一次
+func DirSize(dir string) (int64, error) {
+	var size int64
+	inodes := make(map[uint64]bool)
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Nlink > 1 {
+			if inodes[st.Ino] {
+				return nil
+			}
+			inodes[st.Ino] = true
+		}
+		size += info.Size()
+		return nil
+	})
+
+	return size, err
+}